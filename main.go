@@ -1,30 +1,25 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/cookiejar"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
-	"sync"
-	"text/tabwriter"
+	"syscall"
 	"time"
 
-	"github.com/jaytaylor/html2text"
+	"github.com/kylechadha/go-search/searcher"
 	"github.com/timehop/golog/log"
 )
 
-// Set the maximum number of concurrent requests to be executed.
-var maxReqs = 20
-
-// result type definition.
-type result struct {
-	site  string
-	found bool
-	err   error
-}
-
 func main() {
 	// Code used to profile the application.
 	// cfg := profile.Config{
@@ -37,234 +32,417 @@ func main() {
 	// Record the start time of execution.
 	start := time.Now()
 
-	// Define flags for the input file, search term, and log level.
-	term := flag.String("search", "", "required: please provide a search term")
+	// The 'fetch' command fetches the pages and saves them to a snapshot
+	// without searching them, so they can be searched later with -snapshot.
+	args := os.Args[1:]
+	fetchOnly := len(args) > 0 && args[0] == "fetch"
+	if fetchOnly {
+		args = args[1:]
+	}
+
+	// Define flags for the input file, search terms, match options, output, and log level.
+	var terms, headers, warcFiles stringsFlag
+	flag.Var(&terms, "search", "required: please provide a search term (may be repeated)")
 	path := flag.String("input", "urls.txt", "enter the location of the file containing URLs")
+	urlColumn := flag.String("url-column", "", "the column containing URLs, by 1-based index or header name (default: detected)")
+	columns := flag.String("columns", "", "comma-separated input columns to carry through to the results, by 1-based index or header name")
+	sortBy := flag.String("sort", "", "sort results by 'input' order or by a carried column (default: completion order)")
+	mode := flag.String("mode", "substring", "match mode: substring, regex, or whole-word")
+	normalize := flag.String("normalize", "nfkc", "Unicode normalization applied before matching: nfkc or nfc")
+	ignoreAccents := flag.Bool("ignore-accents", false, "match terms regardless of accents, e.g. 'cafe' matches 'café'")
+	target := flag.String("target", "text", "the part of each page to search: text, raw (the HTML), attributes (tag attributes), or scripts (script sources and inline scripts)")
+	regions := flag.String("regions", "", "comma-separated regions of each page to search: title, meta, headings, links, alt, or body (default: body)")
+	selector := flag.String("selector", "", "only search the elements matching a CSS selector, e.g. 'article .content' (in addition to any -regions)")
+	snippets := flag.Int("snippets", 3, "maximum number of context snippets to record for each site")
+	window := flag.Int("snippet-window", searcher.DefaultSnippetWindow, "number of bytes of context on each side of a match in a snippet")
+	maxBodySize := flag.Int64("max-body-size", searcher.DefaultMaxBodySize, "maximum number of bytes read from each page")
+	countAll := flag.Bool("count-all", false, "read every page to the end to count every occurrence, rather than stopping once every term is found")
+	crawlDepth := flag.Int("crawl-depth", 0, "follow links on each site up to this many links away from its page, searching every page reached (0 to only search the site's page)")
+	crawlPages := flag.Int("crawl-pages", searcher.DefaultCrawlPages, "maximum number of pages fetched for each site when crawling")
+	crawlSameDomain := flag.Bool("crawl-same-domain", false, "when crawling, follow links to any host under the site's registrable domain rather than only the same host")
+	sitemaps := flag.Bool("sitemaps", false, "also search the pages listed in each site's sitemaps (from robots.txt, or else /sitemap.xml)")
+	sitemapPages := flag.Int("sitemap-pages", searcher.DefaultSitemapPages, "maximum number of pages from each site's sitemaps to search")
+	hostConcurrency := flag.Int("host-concurrency", 0, "maximum number of concurrent requests to a single host (0 for no limit)")
+	hostDelay := flag.Duration("host-delay", 0, "minimum delay between requests to a single host")
+	rps := flag.Float64("rps", 0, "maximum number of requests per second across all hosts (0 for no limit)")
+	respectRobots := flag.Bool("robots", false, "respect robots.txt, skipping disallowed URLs and applying any Crawl-delay")
+	robotsUserAgent := flag.String("robots-user-agent", searcher.DefaultRobotsUserAgent, "the user agent used to select robots.txt rules")
+	attempts := flag.Int("attempts", searcher.DefaultRetryPolicy.MaxAttempts, "maximum number of requests for each site, including the first and any falling back to HTTP or the www host")
+	retryDelay := flag.Duration("retry-delay", searcher.DefaultRetryPolicy.BaseDelay, "delay before the first retry, doubling with each further retry")
+	retryMaxDelay := flag.Duration("retry-max-delay", searcher.DefaultRetryPolicy.MaxDelay, "maximum delay between retries")
+	retryOn := flag.String("retry-on", "429,502,503,504", "comma-separated HTTP status codes to retry")
+	timeout := flag.Duration("timeout", searcher.DefaultTimeout, "overall timeout for each request")
+	connectTimeout := flag.Duration("connect-timeout", 0, "timeout for establishing a connection (0 for only the overall timeout)")
+	tlsTimeout := flag.Duration("tls-timeout", 0, "timeout for the TLS handshake (0 for only the overall timeout)")
+	headerTimeout := flag.Duration("response-header-timeout", 0, "timeout for receiving the response headers (0 for only the overall timeout)")
+	userAgent := flag.String("user-agent", searcher.DefaultUserAgent, "the User-Agent header sent with each request")
+	flag.Var(&headers, "header", "an extra header sent with each request, as 'Name: value' (may be repeated)")
+	cookies := flag.Bool("cookies", false, "keep cookies set by responses and send them with later requests")
+	proxy := flag.String("proxy", "", "the URL of an HTTP, HTTPS or SOCKS5 proxy, e.g. socks5://localhost:1080 (default: from HTTP_PROXY/HTTPS_PROXY)")
+	caFile := flag.String("ca-file", "", "a PEM file of CA certificates to trust instead of the system roots")
+	certFile := flag.String("cert", "", "a PEM client certificate file, used with -key")
+	keyFile := flag.String("key", "", "a PEM client key file, used with -cert")
+	insecure := flag.Bool("insecure", false, "skip verification of server certificates")
+	cacheDir := flag.String("cache", "", "cache responses in this directory, revalidating stale ones with conditional requests, so later runs need not refetch them")
+	cacheMaxAge := flag.Duration("cache-max-age", 0, "use cached responses younger than this without revalidating them, overriding their Cache-Control and Expires headers")
+	cacheSize := flag.Int64("cache-size", searcher.DefaultCacheSize>>20, "maximum size of the cache in megabytes, evicting the least recently used responses")
+	snapshotDir := flag.String("snapshot", "", "with 'fetch', the directory to save the pages fetched to; otherwise a saved snapshot to search instead of the network")
+	snapshotHTML := flag.Bool("snapshot-html", false, "with 'fetch', save each page's HTML as well as its text, so the snapshot can be searched with any -target, -regions or -selector")
+	warcOut := flag.String("warc-out", "", "write every request and response to this WARC file, gzipping each record if it ends in .gz")
+	flag.Var(&warcFiles, "warc", "a WARC file to search instead of the network, uncompressed or gzipped (may be repeated)")
+	searchErrorPages := flag.Bool("search-error-pages", false, "also search the body of non-2xx responses")
+	format := flag.String("format", "text", "output format: text, json, or ndjson")
+	output := flag.String("output", "", "enter the location of the results file, or - for stdout (default \"results.txt\", or .json/.ndjson for those formats)")
 	verbose := flag.Bool("verbose", false, "verbose logging option")
-	flag.Parse()
+	flag.CommandLine.Parse(args)
 
 	// Set the log level based on the -verbose flag.
 	if *verbose == true {
 		log.SetLevel(4)
 	}
 
-	// Read the input file.
-	urls, err := readFile(*path)
-	if err != nil {
-		log.Fatal("go-search", "Error reading from urls file", "error", err)
+	// When the results are written to stdout, send logs and
+	// progress feedback to stderr so they don't mix.
+	console := io.Writer(os.Stdout)
+	if *output == "-" {
+		console = os.Stderr
+		log.SetOutput(os.Stderr)
 	}
 
-	// Pass the search term and slice of URLs to the search method.
-	// Note: Remove the first item of the urls slice (the column name).
-	results := search(*term, urls[1:])
+	// If no search term was provided, exit. Fetching needs none, but
+	// needs a snapshot to save the pages to.
+	if fetchOnly && *snapshotDir == "" {
+		log.Fatal("go-search", "No snapshot directory was provided. Expected arguments: 'fetch -snapshot=dir'.")
+	}
+	if len(terms) == 0 && !fetchOnly {
+		log.Fatal("go-search", "No search term was provided. Expected arguments: '-search=searchTerm'.")
+	}
 
-	// Write to the output file.
-	err = writeFile(results)
+	// Parse the match mode.
+	matchMode, err := searcher.ParseMode(*mode)
 	if err != nil {
-		log.Fatal("go-search", "Error writing to results file", "error", err)
+		log.Fatal("go-search", "Invalid -mode flag", "error", err)
 	}
 
-	// Log the total execution time.
-	log.Info("go-search", fmt.Sprintf("Search took %s", time.Since(start)))
-}
-
-// readFile takes the file path of a csv file containing
-// URLs in the second column, and returns a slice of URLs.
-func readFile(path string) ([]string, error) {
-
-	log.Info("go-search", "Reading from the input file")
-
-	// Open the file.
-	f, err := os.Open(path)
+	// Parse the normalization form.
+	normalization, err := searcher.ParseNormalization(*normalize)
 	if err != nil {
-		return nil, err
+		log.Fatal("go-search", "Invalid -normalize flag", "error", err)
 	}
-	defer f.Close()
 
-	// Read the csv data.
-	r := csv.NewReader(f)
-	rawData, err := r.ReadAll()
+	// Parse the target and regions to search.
+	searchTarget, err := searcher.ParseTarget(*target)
+	if err != nil {
+		log.Fatal("go-search", "Invalid -target flag", "error", err)
+	}
+	searchRegions, err := searcher.ParseRegions(*regions)
 	if err != nil {
-		return nil, err
+		log.Fatal("go-search", "Invalid -regions flag", "error", err)
 	}
 
-	// Construct a slice of URLs.
-	var urls []string
-	for _, row := range rawData {
-		urls = append(urls, row[1])
+	// Parse the status codes to retry.
+	retryCodes := []int{}
+	for _, code := range strings.Split(*retryOn, ",") {
+		if code = strings.TrimSpace(code); code == "" {
+			continue
+		}
+		n, err := strconv.Atoi(code)
+		if err != nil {
+			log.Fatal("go-search", "Invalid -retry-on flag", "error", err)
+		}
+		retryCodes = append(retryCodes, n)
 	}
 
-	return urls, nil
-}
+	// Configure the http client.
+	client := searcher.ClientOptions{
+		UserAgent:             *userAgent,
+		Header:                http.Header{},
+		ConnectTimeout:        *connectTimeout,
+		TLSHandshakeTimeout:   *tlsTimeout,
+		ResponseHeaderTimeout: *headerTimeout,
+	}
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			log.Fatal("go-search", fmt.Sprintf("Invalid -header flag %q: expected 'Name: value'", header))
+		}
+		client.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if *cookies {
+		client.Jar, _ = cookiejar.New(nil)
+	}
+	if *proxy != "" {
+		client.Proxy, err = url.Parse(*proxy)
+		if err != nil || client.Proxy.Host == "" {
+			log.Fatal("go-search", "Invalid -proxy flag", "error", fmt.Sprintf("expected a URL such as http://host:port, got %q", *proxy))
+		}
+	}
+	if *caFile != "" || *certFile != "" || *keyFile != "" || *insecure {
+		client.TLSConfig, err = searcher.LoadTLSConfig(*caFile, *certFile, *keyFile, *insecure)
+		if err != nil {
+			log.Fatal("go-search", "Error loading TLS configuration", "error", err)
+		}
+	}
 
-// writeFile takes a slice of results and writes them
-// to 'results.txt' in tab-separated columns.
-func writeFile(results []result) error {
+	if *cacheDir != "" {
+		client.Cache, err = searcher.OpenCache(*cacheDir, searcher.CacheOptions{
+			MaxAge:  *cacheMaxAge,
+			MaxSize: *cacheSize << 20,
+		})
+		if err != nil {
+			log.Fatal("go-search", "Error opening the cache", "error", err)
+		}
+	}
 
-	log.Info("go-search", "Writing to the output file")
+	// Search the WARC files instead of the network, and
+	// record every request and response to a new WARC file.
+	if len(warcFiles) > 0 {
+		if *snapshotDir != "" && !fetchOnly {
+			log.Fatal("go-search", "The -warc and -snapshot flags cannot be used together")
+		}
+		client.Archive, err = searcher.OpenWARC(warcFiles...)
+		if err != nil {
+			log.Fatal("go-search", "Error reading the WARC files", "error", err)
+		}
+	}
+	if *warcOut != "" {
+		client.WARC, err = searcher.CreateWARC(*warcOut)
+		if err != nil {
+			log.Fatal("go-search", "Error creating the WARC file", "error", err)
+		}
+	}
 
-	// Create the file.
-	f, err := os.Create("results.txt")
+	// Parse the output format.
+	outFormat, err := searcher.ParseFormat(*format)
 	if err != nil {
-		return err
+		log.Fatal("go-search", "Invalid -format flag", "error", err)
 	}
-	defer f.Close()
-
-	// Create a new tabwriter.Writer and specify the output and
-	// formatting (tab-separated columns with a tab stop of 4).
-	// FYI: This will look nice in a text editor, but not notepad.
-	w := new(tabwriter.Writer)
-	w.Init(
-		f,    // output
-		0,    // minwidth
-		4,    // tabwidth
-		0,    // padding
-		'\t', // padchar
-		0,    // flags
-	)
-
-	// Range through the results and construct the fileContents.
-	fileContents := "Site\tFound\tError\t\n"
-	for _, result := range results {
-		if result.err != nil {
-			fileContents += fmt.Sprintf("%s\t%s\t%s\n", result.site, "", result.err.Error())
+
+	// Create the snapshot to save the pages to, or open the one to search.
+	var snapshot *searcher.Snapshot
+	if *snapshotDir != "" {
+		if fetchOnly {
+			snapshot, err = searcher.CreateSnapshot(*snapshotDir, *snapshotHTML)
 		} else {
-			fileContents += fmt.Sprintf("%s\t%t\t%v\n", result.site, result.found, "")
+			snapshot, err = searcher.OpenSnapshot(*snapshotDir)
+		}
+		if err != nil {
+			log.Fatal("go-search", "Error opening the snapshot", "error", err)
 		}
 	}
 
-	// Write the fileContents to the file.
-	n, err := fmt.Fprintf(w, fileContents)
-	if err != nil {
-		return err
+	// Read the input file. When searching a snapshot without an input
+	// file, every site in the snapshot is searched.
+	inputGiven := false
+	flag.Visit(func(f *flag.Flag) {
+		inputGiven = inputGiven || f.Name == "input"
+	})
+	var records []searcher.Record
+	if snapshot != nil && !fetchOnly && !inputGiven {
+		records = snapshot.Records()
+	} else {
+		inputOpts := searcher.InputOptions{URLColumn: *urlColumn}
+		if *columns != "" {
+			inputOpts.Columns = strings.Split(*columns, ",")
+		}
+		records, err = searcher.ReadFile(*path, inputOpts)
+		if err != nil {
+			log.Fatal("go-search", "Error reading from urls file", "error", err)
+		}
 	}
 
-	// Flush the writer.
-	err = w.Flush()
-	if err != nil {
-		return err
+	// Check the sort order before searching, rather than failing once
+	// the search is done. NDJSON results are streamed so can't be sorted.
+	if *sortBy != "" {
+		if outFormat == searcher.FormatNDJSON {
+			log.Fatal("go-search", "The -sort flag cannot be used with -format=ndjson")
+		}
+		if *sortBy != "input" && len(records) > 0 && !hasColumn(records[0], *sortBy) {
+			log.Fatal("go-search", fmt.Sprintf("Cannot sort by %q: add it to -columns to carry it through", *sortBy))
+		}
 	}
 
-	// Log the number of bytes written.
-	log.Info("go-search", fmt.Sprintf("%d bytes written to results.txt", n))
+	// Configure the searcher, providing some visual
+	// feedback to the user for each url processed.
+	opts := searcher.Options{
+		Timeout:          *timeout,
+		Client:           client,
+		Mode:             matchMode,
+		Normalization:    normalization,
+		IgnoreAccents:    *ignoreAccents,
+		Target:           searchTarget,
+		Regions:          searchRegions,
+		Selector:         *selector,
+		Snippets:         *snippets,
+		SnippetWindow:    *window,
+		MaxBodySize:      *maxBodySize,
+		CountAll:         *countAll,
+		SearchErrorPages: *searchErrorPages,
+		RespectRobots:    *respectRobots,
+		RobotsUserAgent:  *robotsUserAgent,
+		Crawl: searcher.CrawlOptions{
+			Depth:      *crawlDepth,
+			MaxPages:   *crawlPages,
+			SameDomain: *crawlSameDomain,
+		},
+		Sitemaps: searcher.SitemapOptions{
+			Enabled:  *sitemaps,
+			MaxPages: *sitemapPages,
+		},
+		Limits: searcher.RateLimits{
+			PerHost:           *hostConcurrency,
+			HostDelay:         *hostDelay,
+			RequestsPerSecond: *rps,
+		},
+		Retry: searcher.RetryPolicy{
+			MaxAttempts: *attempts,
+			BaseDelay:   *retryDelay,
+			MaxDelay:    *retryMaxDelay,
+			RetryOn:     retryCodes,
+		},
+	}
+	if fetchOnly {
+		opts.SaveSnapshot = snapshot
+	} else {
+		opts.Snapshot = snapshot
+	}
+	if !*verbose {
+		opts.Progress = func(string) { fmt.Fprint(console, ".") }
+	}
 
-	return nil
-}
+	// Create the output file, named for the output format by default.
+	outPath := *output
+	if outPath == "" {
+		outPath = "results" + outFormat.Ext()
+	}
+	f, err := createOutput(outPath)
+	if err != nil {
+		log.Fatal("go-search", "Error creating results file", "error", err)
+	}
+	out := &countingWriter{w: f}
 
-// search takes a search term and a slice of URLs, fetches the
-// page content for each URL, performs a search, and then returns
-// a slice of results containing the result and any errors encountered.
-func search(term string, urls []string) []result {
+	// NDJSON results are streamed to the output file as they arrive.
+	if outFormat == searcher.FormatNDJSON {
+		opts.OnResult = func(result searcher.Result) {
+			if err := searcher.WriteNDJSON(out, result); err != nil {
+				log.Error("go-search", "Error writing to results file", "error", err)
+			}
+		}
+	}
 
-	// If no search term was provided, exit.
-	if term == "" {
-		log.Fatal("go-search", "No search term was provided. Expected arguments: '-search=searchTerm'.")
+	// Cancel the search on SIGINT or SIGTERM so that the results
+	// gathered so far are still written to the output file.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		// Restore the default behavior so a second signal exits immediately.
+		cancel()
+	}()
+
+	// Pass the search terms and slice of URLs to the searcher, or
+	// only fetch the URLs and save them to the snapshot.
+	log.Info("go-search", "Go ahead, queue up your favorite jam: this will take ~30 seconds")
+	var results []searcher.Result
+	if fetchOnly {
+		results, err = searcher.New(opts).Fetch(ctx, records)
+		if cerr := snapshot.Close(); err == nil && cerr != nil {
+			err = cerr
+		}
 	} else {
-		// Lowercase the search term so our comparisons will be case-insensitive.
-		term = strings.ToLower(term)
+		results, err = searcher.New(opts).SearchRecords(ctx, terms, records)
+	}
+	if client.WARC != nil {
+		if cerr := client.WARC.Close(); cerr != nil {
+			log.Error("go-search", "Error writing the WARC file", "error", cerr)
+		}
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprint(console, "Cancelled!\n")
+		log.Warn("go-search", "Search was interrupted, writing partial results")
+	} else if err != nil {
+		f.Abort()
+		log.Fatal("go-search", "Error searching urls", "error", err)
+	} else {
+		fmt.Fprint(console, "Done!\n")
 	}
 
-	// Create a chan of strings to send work to be processed (urls).
-	// Create a chan of type result to send results.
-	// Set up a WaitGroup so we can track when all goroutines have finished processing.
-	ch := make(chan string)
-	done := make(chan result)
-	var wg sync.WaitGroup
-
-	// Create a single http Client with an 8 second timeout.
-	// From the docs: "Clients should be reused instead of created as
-	// needed. Clients are safe for concurrent use by multiple goroutines."
-	client := &http.Client{
-		Timeout: 8 * time.Second,
+	// Sort the results, if requested.
+	if *sortBy != "" {
+		err = searcher.SortResults(results, *sortBy)
+		if err != nil {
+			f.Abort()
+			log.Fatal("go-search", "Error sorting results", "error", err)
+		}
 	}
 
-	// If there are less than 20 urls in urls.txt, decrease maxReqs to
-	// the number of urls to avoid spinning up unnecessary goroutines.
-	if maxReqs > len(urls) {
-		maxReqs = len(urls)
+	// Write to the output file.
+	err = writeResults(out, outFormat, results)
+	if err == nil {
+		err = f.Commit()
+	}
+	if err != nil {
+		f.Abort()
+		log.Fatal("go-search", "Error writing to results file", "error", err)
 	}
 
-	log.Info("go-search", "Fetching and searching urls...")
-	log.Info("go-search", "Go ahead, queue up your favorite jam: this will take ~30 seconds")
+	// Log the number of bytes written.
+	log.Info("go-search", fmt.Sprintf("%d bytes written to %s", out.n, f.path))
 
-	// Spin up 'maxReqs' number of goroutines.
-	wg.Add(maxReqs)
-	for i := 0; i < maxReqs; i++ {
-		go func() {
-			for {
-				// Recieve work from the chan of strings (urls).
-				site, ok := <-ch
-				if !ok {
-					// If the channel is closed, there is no more work to be done and we can return.
-					wg.Done()
-					return
-				}
-
-				// Provide some visual feedback to the user for each url processed.
-				v := flag.Lookup("verbose")
-				if v.Value.String() == "false" {
-					fmt.Print(".")
-				}
-
-				// Fetch the page content.
-				response, err := client.Get("http://" + site)
-				if err != nil {
-					// If there are errors, try again with the 'www' host prefix.
-					log.Debug("go-search", fmt.Sprintf("Initial request failed for %s, attempting 'www' prefix.", site), "error", err)
-
-					response, err = client.Get("http://www." + site)
-				}
-
-				// If there are still errors, return the error message and continue looping.
-				if err != nil {
-					log.Debug("go-search", fmt.Sprintf("Both requests failed for %s, returning an error.", site), "error", err)
-
-					done <- result{site, false, err}
-					continue
-				}
-
-				// Extract the human-readable text from the response.
-				// Note that FromReader uses html.Parse under the hood,
-				// which reads to EOF in the same manner as ioutil.ReadAll.
-				// https://github.com/jaytaylor/html2text/blob/master/html2text.go#L167
-				text, err := html2text.FromReader(response.Body)
-				response.Body.Close()
-				if err != nil {
-					done <- result{site, false, err}
-					continue
-				}
-
-				// Search for the search term in the page text and return the final result.
-				found := strings.Contains(strings.ToLower(text), term)
-				done <- result{site, found, nil}
-			}
-		}()
-	}
+	// Log the total execution time.
+	log.Info("go-search", fmt.Sprintf("Search took %s", time.Since(start)))
+}
 
-	// Send work to be processed as goroutines become available.
-	go func() {
-		for _, site := range urls {
-			log.Debug("go-search", fmt.Sprintf("Sending work: %s", site))
-			ch <- site
-		}
-	}()
+// writeResults takes a slice of results and writes them to w in
+// the given format. NDJSON results have already been streamed
+// as they arrived, so there is nothing left to write.
+func writeResults(w io.Writer, format searcher.Format, results []searcher.Result) error {
+	switch format {
+	case searcher.FormatJSON:
+		log.Info("go-search", "Writing to the output file")
+		return searcher.WriteJSON(w, results)
+	case searcher.FormatNDJSON:
+		return nil
+	default:
+		log.Info("go-search", "Writing to the output file")
+		_, err := searcher.WriteResults(w, results)
+		return err
+	}
+}
 
-	// Receive the results on the done chan.
-	results := []result{}
-	for i := 0; i < len(urls); i++ {
-		select {
-		case result := <-done:
-			log.Debug("go-search", fmt.Sprintf("Receiving result: %s", result.site))
-			results = append(results, result)
+// hasColumn reports whether the record carries a column with the given name.
+func hasColumn(record searcher.Record, name string) bool {
+	for _, c := range record.Columns {
+		if strings.EqualFold(c.Name, name) {
+			return true
 		}
 	}
+	return false
+}
 
-	// Close the channel as a signal to the goroutines that no additional work needs to be processed.
-	close(ch)
+// countingWriter is an io.Writer that counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write writes p to the underlying writer and adds to the count.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
 
-	// Wait for the goroutines to be done processing.
-	wg.Wait()
+// stringsFlag is a flag.Value that collects the values
+// of a flag that may be repeated.
+type stringsFlag []string
 
-	fmt.Print("Done!\n")
-	return results
+// String returns the collected values as a comma-separated list.
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+// Set appends a value each time the flag is given.
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// outputFile is the destination for results. When writing to a path,
+// the results are written to a temporary file in the same directory
+// and renamed into place by Commit, so a crash never leaves a
+// half-written results file behind.
+type outputFile struct {
+	io.Writer
+
+	// f is the temporary file, or nil when writing to stdout.
+	f *os.File
+
+	// path is the final location of the results file.
+	path string
+}
+
+// createOutput takes the path of the results file, or "-" for
+// stdout, and returns an outputFile ready to be written to.
+func createOutput(path string) (*outputFile, error) {
+	if path == "-" {
+		return &outputFile{Writer: os.Stdout, path: "stdout"}, nil
+	}
+
+	// Create the temporary file alongside the results file so the
+	// rename stays on one filesystem and is atomic.
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.CreateTemp(dir, "."+base+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+
+	// CreateTemp creates files readable only by the owner;
+	// use the usual permissions for the results file.
+	err = f.Chmod(0644)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return &outputFile{Writer: f, f: f, path: path}, nil
+}
+
+// Commit flushes the temporary file to disk and renames it to
+// the results file path. It does nothing when writing to stdout.
+func (o *outputFile) Commit() error {
+	if o.f == nil {
+		return nil
+	}
+
+	err := o.f.Sync()
+	if err == nil {
+		err = o.f.Close()
+	} else {
+		o.f.Close()
+	}
+	if err == nil {
+		err = os.Rename(o.f.Name(), o.path)
+	}
+	if err != nil {
+		os.Remove(o.f.Name())
+	}
+
+	return err
+}
+
+// Abort discards the temporary file, leaving any
+// existing results file untouched.
+func (o *outputFile) Abort() {
+	if o.f == nil {
+		return
+	}
+	o.f.Close()
+	os.Remove(o.f.Name())
+}
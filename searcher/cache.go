@@ -0,0 +1,522 @@
+package searcher
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/timehop/golog/log"
+)
+
+// DefaultCacheSize is the default maximum size of a Cache in bytes.
+const DefaultCacheSize = 1 << 30
+
+// maxCacheEntry caps the size of the responses that are cached.
+const maxCacheEntry = 32 << 20
+
+// cacheStatusHeader is set on responses passed through a Cache to
+// record whether they were served from it: "hit" if the cached
+// response was fresh, "revalidated" if the server confirmed it was
+// unchanged, or "miss" otherwise.
+const cacheStatusHeader = "X-Go-Search-Cache"
+
+// CacheOptions configures a Cache.
+type CacheOptions struct {
+	// MaxAge, if set, overrides the freshness of cached responses:
+	// responses cached for less than MaxAge are used without
+	// contacting the server, and older ones are revalidated.
+	// Otherwise freshness is taken from each response's
+	// Cache-Control max-age or Expires header.
+	MaxAge time.Duration
+
+	// MaxSize is the maximum total size of the cache in bytes. The
+	// least recently used responses are evicted to stay under it.
+	MaxSize int64
+}
+
+// Cache is an on-disk cache of http responses, keyed by URL, used to
+// avoid refetching pages between runs. Stale responses with an ETag
+// or Last-Modified header are revalidated with conditional requests.
+// Only successful (200) responses to GET requests are cached.
+//
+// A Cache is safe for concurrent use, but not by several processes
+// at once.
+type Cache struct {
+	dir  string
+	opts CacheOptions
+
+	// size is the total size of the cached responses.
+	mu   sync.Mutex
+	size int64
+}
+
+// cacheEntry is the metadata of a cached response, stored as a line
+// of JSON before the response body.
+type cacheEntry struct {
+	URL        string      `json:"url"`
+	Status     string      `json:"status"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+
+	// Stored is when the response was received or last revalidated.
+	Stored time.Time `json:"stored"`
+}
+
+// OpenCache takes the path of a directory, creating it if needed, and
+// returns a cache storing responses in it. Responses cached by
+// earlier runs are used.
+func OpenCache(dir string, opts CacheOptions) (*Cache, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultCacheSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Cache{dir: dir, opts: opts}
+
+	// Total the size of the cached responses, removing any
+	// temporary files left behind by an earlier run.
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasSuffix(path, ".tmp") {
+			return os.Remove(path)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		c.size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// path returns the path of the file caching the response for a URL.
+// Files are spread over subdirectories named for the first byte of
+// the hash of the URL.
+func (c *Cache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	key := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, key[:2], key+".cache")
+}
+
+// load opens the cached response for a URL, returning its metadata
+// and a reader positioned at the start of its body.
+func (c *Cache) load(url string) (*cacheEntry, *os.File, *bufio.Reader, error) {
+	f, err := os.Open(c.path(url))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	br := bufio.NewReader(f)
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(line, &entry); err != nil || entry.URL != url {
+		f.Close()
+		return nil, nil, nil, fmt.Errorf("corrupt cache entry for %s", url)
+	}
+	return &entry, f, br, nil
+}
+
+// fresh reports whether the cache holds a fresh response for a URL.
+func (c *Cache) fresh(url string) bool {
+	entry, f, _, err := c.load(url)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return c.isFresh(entry)
+}
+
+// isFresh reports whether a cached response can be used without
+// revalidating it.
+func (c *Cache) isFresh(entry *cacheEntry) bool {
+	age := time.Since(entry.Stored)
+	if secs, err := strconv.Atoi(entry.Header.Get("Age")); err == nil && secs > 0 {
+		age += time.Duration(secs) * time.Second
+	}
+	if c.opts.MaxAge > 0 {
+		return age < c.opts.MaxAge
+	}
+
+	directives := cacheControl(entry.Header)
+	if _, ok := directives["no-cache"]; ok {
+		return false
+	}
+	if v, ok := directives["max-age"]; ok {
+		secs, err := strconv.Atoi(v)
+		return err == nil && age < time.Duration(secs)*time.Second
+	}
+	if v := entry.Header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return false
+		}
+		date, err := http.ParseTime(entry.Header.Get("Date"))
+		if err != nil {
+			date = entry.Stored
+		}
+		return age < expires.Sub(date)
+	}
+	return false
+}
+
+// cacheControl returns the directives of a Cache-Control header,
+// mapping each lowercased name to its value, if any.
+func cacheControl(header http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, field := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		if name != "" {
+			directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return directives
+}
+
+// cacheable reports whether a response may be cached.
+func cacheable(response *http.Response) bool {
+	if response.StatusCode != http.StatusOK || response.Header.Get("Vary") == "*" {
+		return false
+	}
+	if response.ContentLength > maxCacheEntry {
+		return false
+	}
+	_, noStore := cacheControl(response.Header)["no-store"]
+	return !noStore
+}
+
+// transport returns an http.RoundTripper serving GET requests from the
+// cache and storing the responses from next in it.
+func (c *Cache) transport(next http.RoundTripper) http.RoundTripper {
+	return &cacheTransport{cache: c, next: next}
+}
+
+// cacheTransport is an http.RoundTripper backed by a Cache.
+type cacheTransport struct {
+	cache *Cache
+	next  http.RoundTripper
+}
+
+// RoundTrip serves a request from the cache if it holds a fresh
+// response, revalidates a stale response if it can, and otherwise
+// passes the request on and caches the response.
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" || req.Header.Get("Range") != "" {
+		return t.next.RoundTrip(req)
+	}
+	url := req.URL.String()
+
+	entry, f, body, err := t.cache.load(url)
+	if err == nil && t.cache.isFresh(entry) {
+		t.cache.touch(url)
+		return entry.response(req, f, body, "hit"), nil
+	}
+
+	// Ask the server whether a stale response has changed.
+	if entry != nil {
+		etag, modified := entry.Header.Get("ETag"), entry.Header.Get("Last-Modified")
+		if etag != "" || modified != "" {
+			req = req.Clone(req.Context())
+			if etag != "" {
+				req.Header.Set("If-None-Match", etag)
+			}
+			if modified != "" {
+				req.Header.Set("If-Modified-Since", modified)
+			}
+		}
+	}
+
+	response, err := t.next.RoundTrip(req)
+	if err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return nil, err
+	}
+
+	// If it has not changed, update its headers and serve it.
+	if entry != nil && response.StatusCode == http.StatusNotModified {
+		io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+		response.Body.Close()
+
+		for name, values := range response.Header {
+			entry.Header[name] = values
+		}
+		entry.Stored = time.Now()
+		if err := t.cache.store(url, entry, body); err != nil {
+			log.Debug("go-search", fmt.Sprintf("Could not update the cached response for %s.", url), "error", err)
+		}
+		f.Close()
+
+		entry, f, body, err = t.cache.load(url)
+		if err != nil {
+			return nil, err
+		}
+		return entry.response(req, f, body, "revalidated"), nil
+	}
+	if f != nil {
+		f.Close()
+	}
+
+	response.Header.Set(cacheStatusHeader, "miss")
+	if cacheable(response) {
+		response.Body = t.cache.recorder(url, response)
+	}
+	return response, nil
+}
+
+// response returns a cached response to a request, whose body is
+// read from body and closes f.
+func (e *cacheEntry) response(req *http.Request, f *os.File, body io.Reader, status string) *http.Response {
+	header := e.Header.Clone()
+	header.Set(cacheStatusHeader, status)
+	return &http.Response{
+		Status:        e.Status,
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          readCloser{Reader: body, Closer: f},
+		ContentLength: -1,
+		Request:       req,
+	}
+}
+
+// readCloser combines a Reader and a Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// touch marks the cached response for a URL as recently used,
+// so that it is evicted last.
+func (c *Cache) touch(url string) {
+	now := time.Now()
+	os.Chtimes(c.path(url), now, now)
+}
+
+// store caches a response, given its metadata and its body.
+func (c *Cache) store(url string, entry *cacheEntry, body io.Reader) error {
+	w, err := c.create(url, entry)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w.file, body); err != nil {
+		w.abort()
+		return err
+	}
+	return w.commit()
+}
+
+// cacheWriter writes a response to a temporary file, which is
+// moved into place once the whole response has been written.
+type cacheWriter struct {
+	cache *Cache
+	url   string
+	file  *os.File
+}
+
+// create starts caching a response, writing its metadata to a
+// temporary file.
+func (c *Cache) create(url string, entry *cacheEntry) (*cacheWriter, error) {
+	path := c.path(url)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	w := &cacheWriter{cache: c, url: url, file: f}
+
+	line, err := json.Marshal(entry)
+	if err == nil {
+		_, err = f.Write(append(line, '\n'))
+	}
+	if err != nil {
+		w.abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+// abort discards the temporary file.
+func (w *cacheWriter) abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+// commit moves the temporary file into place, replacing any earlier
+// response for the URL, and evicts responses if the cache is full.
+func (w *cacheWriter) commit() error {
+	info, err := w.file.Stat()
+	if err == nil {
+		err = w.file.Close()
+	}
+	if err != nil {
+		w.abort()
+		return err
+	}
+
+	c := w.cache
+	path := c.path(w.url)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var old int64
+	if prev, err := os.Stat(path); err == nil {
+		old = prev.Size()
+	}
+	if err := os.Rename(w.file.Name(), path); err != nil {
+		os.Remove(w.file.Name())
+		return err
+	}
+	c.size += info.Size() - old
+
+	if c.size > c.opts.MaxSize {
+		c.evict()
+	}
+	return nil
+}
+
+// evict removes the least recently used responses until the cache
+// is under its maximum size. It must be called with mu held.
+func (c *Cache) evict() {
+	type file struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".cache") {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			files = append(files, file{path: path, size: info.Size(), modTime: info.ModTime()})
+		}
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	for _, f := range files {
+		if c.size <= c.opts.MaxSize {
+			break
+		}
+		if err := os.Remove(f.path); err == nil || errors.Is(err, fs.ErrNotExist) {
+			c.size -= f.size
+		}
+	}
+	log.Debug("go-search", fmt.Sprintf("Evicted responses from the cache, which is now %d bytes.", c.size))
+}
+
+// recorder returns a body that reads a response's body while
+// caching it.
+func (c *Cache) recorder(url string, response *http.Response) io.ReadCloser {
+	entry := &cacheEntry{
+		URL:        url,
+		Status:     response.Status,
+		StatusCode: response.StatusCode,
+		Header:     response.Header.Clone(),
+		Stored:     time.Now(),
+	}
+	entry.Header.Del(cacheStatusHeader)
+	entry.Header.Del("Content-Length")
+
+	w, err := c.create(url, entry)
+	if err != nil {
+		log.Debug("go-search", fmt.Sprintf("Could not cache the response for %s.", url), "error", err)
+		return response.Body
+	}
+	return &recordingBody{body: response.Body, w: w}
+}
+
+// recordingBody is a response body that writes what is read from it
+// to the cache. The response is cached once the body has been read to
+// the end. If the body is closed first, such as when it is larger than
+// Options.MaxBodySize, the partial response is discarded.
+type recordingBody struct {
+	body io.ReadCloser
+	w    *cacheWriter
+
+	// n is the number of bytes written, and done reports whether
+	// the response has been committed or discarded.
+	n    int64
+	done bool
+}
+
+// Read reads from the body, writing what is read to the cache.
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 && !b.done {
+		b.write(p[:n])
+	}
+	if err == io.EOF && !b.done {
+		b.finish(true)
+	} else if err != nil && !b.done {
+		b.finish(false)
+	}
+	return n, err
+}
+
+// write writes part of the body to the cache, discarding the
+// response if it fails or the response is too large.
+func (b *recordingBody) write(p []byte) {
+	b.n += int64(len(p))
+	if b.n > maxCacheEntry {
+		b.finish(false)
+		return
+	}
+	if _, err := b.w.file.Write(p); err != nil {
+		log.Debug("go-search", fmt.Sprintf("Could not cache the response for %s.", b.w.url), "error", err)
+		b.finish(false)
+	}
+}
+
+// finish commits the response to the cache if it is complete,
+// and otherwise discards it.
+func (b *recordingBody) finish(complete bool) {
+	b.done = true
+	if !complete {
+		b.w.abort()
+		return
+	}
+	if err := b.w.commit(); err != nil {
+		log.Debug("go-search", fmt.Sprintf("Could not cache the response for %s.", b.w.url), "error", err)
+	}
+}
+
+// Close discards the response if the body was not read to the end,
+// and closes it.
+func (b *recordingBody) Close() error {
+	if !b.done {
+		b.finish(false)
+	}
+	return b.body.Close()
+}
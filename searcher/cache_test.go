@@ -0,0 +1,149 @@
+package searcher
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheRecordsCompleteResponses(t *testing.T) {
+	body := strings.Repeat("x", 1<<20)
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		io.WriteString(w, body)
+	}))
+	defer server.Close()
+
+	cache, err := OpenCache(t.TempDir(), CacheOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: cache.transport(http.DefaultTransport)}
+	get := func(path string, n int64) (string, string) {
+		response, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		read, err := io.ReadAll(io.LimitReader(response.Body, n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Header.Get(cacheStatusHeader), string(read)
+	}
+
+	// A response read to the end is cached.
+	if status, _ := get("/full", 2<<20); status != "miss" {
+		t.Errorf("first request for /full was a %s, want a miss", status)
+	}
+	if status, got := get("/full", 2<<20); status != "hit" || got != body {
+		t.Errorf("second request for /full was a %s of %d bytes, want a hit of %d bytes", status, len(got), len(body))
+	}
+
+	// A response closed before it is read to the end is discarded,
+	// without reading the rest of it.
+	start := time.Now()
+	if status, _ := get("/partial", 10); status != "miss" {
+		t.Errorf("first request for /partial was a %s, want a miss", status)
+	}
+	if status, got := get("/partial", 2<<20); status != "miss" || got != body {
+		t.Errorf("second request for /partial was a %s of %d bytes, want a miss of %d bytes", status, len(got), len(body))
+	}
+	if status, _ := get("/partial", 2<<20); status != "hit" {
+		t.Errorf("third request for /partial was a %s, want a hit", status)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("requests took %v", elapsed)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("server got %d requests, want 3", n)
+	}
+}
+
+func TestSearchCachesPagesStoppedEarly(t *testing.T) {
+	page := "<p>needle</p>" + strings.Repeat("<p>more text</p>", 20000)
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		io.WriteString(w, page)
+	}))
+	defer server.Close()
+
+	cache, err := OpenCache(t.TempDir(), CacheOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(Options{Client: ClientOptions{Cache: cache}})
+	for i, want := range []string{"miss", "hit", "hit"} {
+		results, err := s.Search(context.Background(), []string{"needle"}, []string{server.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := results[0]
+		if !result.Found || !result.StoppedEarly || result.Truncated || result.Cache != want {
+			t.Errorf("run %d: found %v, stopped early %v, truncated %v, cache %q; want found, stopped early, cache %q", i+1, result.Found, result.StoppedEarly, result.Truncated, result.Cache, want)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("server got %d requests, want 1", n)
+	}
+
+	// Pages larger than MaxBodySize are not cached.
+	s = New(Options{Client: ClientOptions{Cache: cache}, MaxBodySize: 1000})
+	for i := 0; i < 2; i++ {
+		results, err := s.Search(context.Background(), []string{"needle"}, []string{server.URL + "/large"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := results[0]; !result.Found || !result.Truncated || result.Cache != "miss" {
+			t.Errorf("large page run %d: found %v, truncated %v, cache %q; want found, truncated, a miss", i+1, result.Found, result.Truncated, result.Cache)
+		}
+	}
+}
+
+func TestRecordingBodyCloseDoesNotDrain(t *testing.T) {
+	cache, err := OpenCache(t.TempDir(), CacheOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &endlessReader{}
+	response := &http.Response{Status: "200 OK", StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(r)}
+	body := cache.recorder("http://example.com/", response)
+
+	buf := make([]byte, 100)
+	if _, err := io.ReadFull(body, buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := body.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if r.n != 100 {
+		t.Errorf("read %d bytes of the body, want 100", r.n)
+	}
+	if _, f, _, err := cache.load("http://example.com/"); err == nil {
+		f.Close()
+		t.Errorf("partial response was cached")
+	}
+}
+
+// endlessReader is a reader of endless data that counts the bytes
+// read.
+type endlessReader struct {
+	n int
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	r.n += len(p)
+	return len(p), nil
+}
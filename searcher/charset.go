@@ -0,0 +1,63 @@
+package searcher
+
+import (
+	"bytes"
+	"io"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// charsetPreview is the number of bytes examined for a byte order
+// mark or meta tag, as recommended by the HTML5 spec.
+const charsetPreview = 1024
+
+// decodeBody takes a response body and its Content-Type header and
+// returns a reader converting the body to UTF-8, along with the name
+// of the detected character set.
+//
+// The character set is determined from any byte order mark, then the
+// Content-Type header, then any <meta> tag in the first 1024 bytes,
+// falling back to UTF-8 if the content has non-ASCII characters and
+// is valid UTF-8, and otherwise to windows-1252. Any byte order mark
+// is dropped from the text.
+func decodeBody(body io.Reader, contentType string) (io.Reader, string, error) {
+	preview := make([]byte, charsetPreview)
+	n, err := io.ReadFull(body, preview)
+	sniff := preview
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		preview = preview[:n]
+		body = bytes.NewReader(preview)
+
+		// DetermineEncoding ignores what may be a partial character at
+		// the end of the preview, but here the preview is the whole
+		// body, so its last character is complete. Pad it so that the
+		// character is still considered.
+		sniff = append(preview[:n:n], ' ')
+	case err != nil:
+		return nil, "", err
+	default:
+		body = io.MultiReader(bytes.NewReader(preview), body)
+	}
+
+	e, name, _ := charset.DetermineEncoding(sniff, contentType)
+	switch {
+	case hasBOM(preview):
+		// The decoders keep the byte order mark, so use one that
+		// drops it.
+		body = transform.NewReader(body, unicode.BOMOverride(e.NewDecoder()))
+	case e != encoding.Nop:
+		body = transform.NewReader(body, e.NewDecoder())
+	}
+	return body, name, nil
+}
+
+// hasBOM reports whether b starts with a UTF-8 or UTF-16 byte order mark.
+func hasBOM(b []byte) bool {
+	return bytes.HasPrefix(b, []byte("\xef\xbb\xbf")) ||
+		bytes.HasPrefix(b, []byte("\xfe\xff")) ||
+		bytes.HasPrefix(b, []byte("\xff\xfe"))
+}
@@ -0,0 +1,65 @@
+package searcher
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBody(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        string
+		charset     string
+	}{
+		// The Content-Type header names the character set.
+		{"text/html; charset=utf-8", "café", "café", "utf-8"},
+		{"text/html; charset=windows-1252", "caf\xe9 \x93quoted\x94", "café “quoted”", "windows-1252"},
+		{"text/html; charset=ISO-8859-1", "caf\xe9", "café", "windows-1252"},
+		{"text/html; charset=shift_jis", "\x93\xfa\x96\x7b", "日本", "shift_jis"},
+		{"text/html; charset=koi8-r", "\xd0\xd2\xc9\xd7\xc5\xd4", "привет", "koi8-r"},
+
+		// A byte order mark takes precedence over the header.
+		{"text/html; charset=windows-1252", "\xef\xbb\xbfcafé", "café", "utf-8"},
+		{"text/html", "\xff\xfec\x00a\x00f\x00\xe9\x00", "café", "utf-16le"},
+		{"text/html", "\xfe\xff\x00c\x00a\x00f\x00\xe9", "café", "utf-16be"},
+
+		// Otherwise a <meta> tag in the first 1024 bytes is used.
+		{"text/html", `<meta charset="shift_jis"><p>` + "\x93\xfa\x96\x7b", `<meta charset="shift_jis"><p>日本`, "shift_jis"},
+		{"text/html", `<meta http-equiv="Content-Type" content="text/html; charset=windows-1251"><p>` + "\xcf\xf0\xe8",
+			`<meta http-equiv="Content-Type" content="text/html; charset=windows-1251"><p>При`, "windows-1251"},
+		{"text/html; charset=utf-8", `<meta charset="windows-1252"><p>café`, `<meta charset="windows-1252"><p>café`, "utf-8"},
+		{"text/html", strings.Repeat(" ", charsetPreview) + `<meta charset="shift_jis">` + "caf\xe9",
+			strings.Repeat(" ", charsetPreview) + `<meta charset="shift_jis">café`, "windows-1252"},
+
+		// Failing that, UTF-8 if the body has non-ASCII characters and
+		// is valid UTF-8, or windows-1252.
+		{"text/html", "café", "café", "utf-8"},
+		{"text/html", "日本", "日本", "utf-8"},
+		{"", "caf\xe9", "café", "windows-1252"},
+		{"text/html", "cafe", "cafe", "windows-1252"},
+		{"text/html", "", "", "windows-1252"},
+		{"text/html", strings.Repeat("a", charsetPreview-3) + "é", strings.Repeat("a", charsetPreview-3) + "é", "utf-8"},
+
+		// Text beyond the first 1024 bytes is decoded too.
+		{"text/html; charset=windows-1252", strings.Repeat("\xe9", 3000), strings.Repeat("é", 3000), "windows-1252"},
+		{"text/html", strings.Repeat("é", 3000), strings.Repeat("é", 3000), "utf-8"},
+	}
+
+	for _, tt := range tests {
+		r, name, err := decodeBody(strings.NewReader(tt.body), tt.contentType)
+		if err != nil {
+			t.Errorf("decodeBody(%.40q, %q) failed: %v", tt.body, tt.contentType, err)
+			continue
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Errorf("decodeBody(%.40q, %q): reading failed: %v", tt.body, tt.contentType, err)
+			continue
+		}
+		if string(got) != tt.want || name != tt.charset {
+			t.Errorf("decodeBody(%.40q, %q) = %.40q, %q; want %.40q, %q", tt.body, tt.contentType, got, name, tt.want, tt.charset)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package searcher
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// DefaultUserAgent is the default User-Agent header sent with each
+// request. Go's own default is blocked by many CDNs.
+const DefaultUserAgent = "Mozilla/5.0 (compatible; go-search/1.0)"
+
+// ClientOptions configures the http client used by a Searcher.
+// The zero value uses DefaultUserAgent and Go's default transport
+// settings, with any proxy taken from the environment.
+type ClientOptions struct {
+	// UserAgent is the User-Agent header sent with each request.
+	UserAgent string
+
+	// Header holds extra headers sent with each request. A Host
+	// header overrides the host sent to the server.
+	Header http.Header
+
+	// Jar, if set, stores cookies set by responses and sends them
+	// with later requests.
+	Jar http.CookieJar
+
+	// Proxy is the URL of an HTTP, HTTPS or SOCKS5 proxy. If nil,
+	// the proxy is taken from the HTTP_PROXY, HTTPS_PROXY and
+	// NO_PROXY environment variables.
+	Proxy *url.URL
+
+	// TLSConfig, if set, configures TLS connections, e.g. with a
+	// custom CA bundle or client certificates. See LoadTLSConfig.
+	TLSConfig *tls.Config
+
+	// ConnectTimeout is the timeout for establishing a connection.
+	// If zero, only the overall Options.Timeout applies.
+	ConnectTimeout time.Duration
+
+	// TLSHandshakeTimeout is the timeout for the TLS handshake.
+	// If zero, only the overall Options.Timeout applies.
+	TLSHandshakeTimeout time.Duration
+
+	// ResponseHeaderTimeout is the timeout for receiving the response
+	// headers once the request is sent. If zero, only the overall
+	// Options.Timeout applies.
+	ResponseHeaderTimeout time.Duration
+
+	// Cache, if set, caches responses on disk so that later runs
+	// need not refetch them. Pages are read to the end, up to
+	// Options.MaxBodySize bytes, even once searching them stops, so
+	// that they are cached whole. See OpenCache.
+	Cache *Cache
+
+	// WARC, if set, records every request and response to a WARC
+	// file, including those served from the cache. Like with Cache,
+	// pages are read to the end, up to Options.MaxBodySize bytes, so
+	// that they are recorded whole. See CreateWARC.
+	WARC *WARCWriter
+
+	// Archive, if set, serves responses from WARC files instead of
+	// the network. See OpenWARC.
+	Archive *WARCArchive
+}
+
+// newClient takes the client options and overall timeout and returns
+// an http client configured with them.
+func newClient(opts ClientOptions, timeout time.Duration) *http.Client {
+	proxy := http.ProxyFromEnvironment
+	if opts.Proxy != nil {
+		proxy = http.ProxyURL(opts.Proxy)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.DialContext = (&net.Dialer{
+		Timeout:   opts.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = opts.TLSHandshakeTimeout
+	transport.ResponseHeaderTimeout = opts.ResponseHeaderTimeout
+	if opts.TLSConfig != nil {
+		transport.TLSClientConfig = opts.TLSConfig
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Jar:       opts.Jar,
+		Timeout:   timeout,
+	}
+	if opts.Archive != nil {
+		client.Transport = opts.Archive.transport()
+	}
+	if opts.Cache != nil {
+		client.Transport = opts.Cache.transport(client.Transport)
+	}
+	if opts.WARC != nil {
+		client.Transport = opts.WARC.transport(client.Transport)
+	}
+	return client
+}
+
+// setHeaders sets the User-Agent and any extra headers on a request.
+func (opts ClientOptions) setHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", opts.UserAgent)
+	for name, values := range opts.Header {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			if len(values) > 0 {
+				req.Host = values[0]
+			}
+			continue
+		}
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+}
+
+// LoadTLSConfig takes the paths of a PEM CA bundle and of a PEM client
+// certificate and key, any of which may be empty, and returns a TLS
+// config using them. The CA bundle replaces the system roots. If
+// insecure is true, server certificates are not verified.
+func LoadTLSConfig(caFile, certFile, keyFile string, insecure bool) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: insecure}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("a client certificate and key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
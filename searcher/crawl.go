@@ -0,0 +1,312 @@
+package searcher
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+
+	"github.com/timehop/golog/log"
+	"golang.org/x/net/publicsuffix"
+)
+
+// DefaultCrawlPages is the default maximum number of pages
+// fetched for each site when crawling.
+const DefaultCrawlPages = 50
+
+// CrawlOptions determines how sites are crawled. Crawling is
+// disabled unless Depth is set.
+type CrawlOptions struct {
+	// Depth is the number of links followed from each site's page
+	// to reach further pages to search. If zero, only the site's
+	// page is searched.
+	Depth int
+
+	// MaxPages is the maximum number of pages fetched for each
+	// site, including its first page.
+	MaxPages int
+
+	// SameDomain follows links to any host under the site's
+	// registrable domain (e.g. from www.example.com to
+	// blog.example.com), rather than only to the same host.
+	SameDomain bool
+}
+
+// enabled reports whether sites are crawled, which they are
+// if more than one page can be fetched for each.
+func (c CrawlOptions) enabled() bool {
+	return c.Depth > 0 && c.MaxPages > 1
+}
+
+// links collects the targets of the links on a page, resolved
+// against the page's URL or the first <base> element's target.
+type links struct {
+	base    *url.URL
+	hasBase bool
+	urls    []*url.URL
+}
+
+// newLinks returns an empty set of links for the page at a URL.
+func newLinks(page *url.URL) *links {
+	return &links{base: page}
+}
+
+// setBase handles the target of a <base> element. Only the
+// first <base> element with a target is used.
+func (l *links) setBase(href string) {
+	if l.hasBase || href == "" {
+		return
+	}
+	if u, err := l.base.Parse(href); err == nil {
+		l.base = u
+		l.hasBase = true
+	}
+}
+
+// add resolves a link's target and adds it, ignoring targets that
+// are not http(s) URLs, such as mailto: and javascript: links.
+func (l *links) add(href string) {
+	if href == "" || strings.HasPrefix(href, "#") {
+		return
+	}
+	u, err := l.base.Parse(href)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	l.urls = append(l.urls, u)
+}
+
+// crawlLink is a link waiting to be crawled, found
+// depth links away from the site's page.
+type crawlLink struct {
+	url   *url.URL
+	depth int
+}
+
+// crawler tracks the pages of a site as it is crawled breadth-first.
+type crawler struct {
+	opts CrawlOptions
+
+	// host is the site's host without any 'www.' prefix, and domain
+	// its registrable domain, if links to it are followed.
+	host, domain string
+
+	// seen holds the key of every URL queued or fetched, and
+	// queue the links waiting to be crawled.
+	seen  map[string]bool
+	queue []crawlLink
+
+	// fetched is the number of pages fetched so far, and
+	// limit the number that may be fetched.
+	fetched, limit int
+}
+
+// newCrawler returns a crawler for a site whose first page was
+// fetched from a URL.
+func newCrawler(opts CrawlOptions, first *url.URL) *crawler {
+	c := &crawler{
+		opts:    opts,
+		host:    strings.TrimPrefix(first.Host, "www."),
+		seen:    map[string]bool{crawlKey(first): true},
+		fetched: 1,
+		limit:   opts.MaxPages,
+	}
+	if opts.SameDomain {
+		c.domain = registrableDomain(first.Hostname())
+	}
+	return c
+}
+
+// registrableDomain returns the registrable domain of a host (e.g.
+// "example.co.uk" for "www.example.co.uk"), or the host itself if it
+// has none, such as an IP address or "localhost".
+func registrableDomain(host string) string {
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+// crawlKey returns the key used to dedupe a URL: its normalized form
+// without the scheme or any 'www.' host prefix.
+func crawlKey(u *url.URL) string {
+	if n, _, err := NormalizeURL(u.String()); err == nil {
+		u = n
+	}
+	return strings.TrimPrefix(u.Host, "www.") + u.RequestURI()
+}
+
+// sameSite reports whether a URL is on the site being crawled.
+func (c *crawler) sameSite(u *url.URL) bool {
+	if c.domain != "" {
+		return registrableDomain(u.Hostname()) == c.domain
+	}
+	return strings.TrimPrefix(u.Host, "www.") == c.host
+}
+
+// visit marks a URL as seen, and reports whether it
+// is on the site and had not been seen before.
+func (c *crawler) visit(u *url.URL) bool {
+	key := crawlKey(u)
+	if c.seen[key] || !c.sameSite(u) {
+		return false
+	}
+	c.seen[key] = true
+	return true
+}
+
+// follows reports whether the links on a page at the given depth are
+// followed, which they are if the pages they lead to could be fetched.
+func (c *crawler) follows(depth int) bool {
+	return depth < c.opts.Depth && c.fetched < c.limit
+}
+
+// enqueue queues the links found on a page at the given depth.
+func (c *crawler) enqueue(l *links, depth int) {
+	for _, u := range l.urls {
+		if c.visit(u) {
+			c.queue = append(c.queue, crawlLink{url: u, depth: depth + 1})
+		}
+	}
+}
+
+// crawl searches the rest of a site breadth-first, starting from the
+// links on its first page, and adds the outcome to the site's result.
+// Pages that cannot be fetched, that respond with a non-2xx status, or
+// that are not HTML are skipped.
+func (s *Searcher) crawl(ctx context.Context, q *query, sc scope, result *Result, c *crawler, first *links) {
+	c.enqueue(first, 0)
+
+	for len(c.queue) > 0 && c.fetched < c.limit && ctx.Err() == nil {
+		link := c.queue[0]
+		c.queue = c.queue[1:]
+		c.fetched++
+
+		page, found, ok := s.crawlPage(ctx, q, sc, result.Site, link.url, c.visit, c.follows(link.depth))
+		if !ok {
+			continue
+		}
+		mergePage(q, s.opts.Snippets, result, page)
+		if found != nil {
+			c.enqueue(found, link.depth)
+		}
+	}
+}
+
+// crawlPage fetches and searches one of a site's pages other than its
+// first, and reports whether it was searched. If the page redirects,
+// visit is called with the URL it redirected to, and the page is
+// skipped unless visit returns true. If follow is set, the targets of
+// the page's links are returned too.
+func (s *Searcher) crawlPage(ctx context.Context, q *query, sc scope, site string, u *url.URL, visit func(u *url.URL) bool, follow bool) (Result, *links, bool) {
+	response, _, err := s.fetch(ctx, u, true)
+	if err != nil {
+		log.Debug("go-search", fmt.Sprintf("Skipping %s while crawling %s.", u, site), "error", err)
+		return Result{}, nil, false
+	}
+
+	// Skip pages redirected off the site or to a page already seen.
+	final := response.Request.URL
+	if crawlKey(final) != crawlKey(u) && !visit(final) {
+		response.Body.Close()
+		return Result{}, nil, false
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 || !isHTML(response.Header.Get("Content-Type")) {
+		log.Debug("go-search", fmt.Sprintf("Skipping %s while crawling %s: %s %s.", final, site, response.Status, response.Header.Get("Content-Type")))
+		response.Body.Close()
+		return Result{}, nil, false
+	}
+
+	var found *links
+	if follow {
+		found = newLinks(final)
+	}
+	page := Result{URL: final.String()}
+	if err := s.searchPage(q, sc, response, &page, found); err != nil {
+		log.Debug("go-search", fmt.Sprintf("Skipping %s while crawling %s.", final, site), "error", err)
+		return Result{}, nil, false
+	}
+	return page, found, true
+}
+
+// isHTML reports whether a Content-Type is HTML, or is missing,
+// in which case the page is assumed to be HTML.
+func isHTML(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	media, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (media == "text/html" || media == "application/xhtml+xml")
+}
+
+// firstPage records that a site's result is the outcome of searching
+// the first of several of its pages, before others are merged into it.
+func firstPage(result *Result) {
+	result.Pages = 1
+	if result.Found {
+		result.MatchedPages = []string{result.URL}
+	}
+	for i := range result.Snippets {
+		result.Snippets[i].URL = result.URL
+	}
+}
+
+// mergePage adds the outcome of searching one of a site's pages to
+// the site's result, keeping up to maxSnippets snippets. If q is nil,
+// the page was only fetched.
+func mergePage(q *query, maxSnippets int, result *Result, page Result) {
+	result.Pages++
+	result.saved = append(result.saved, page.saved...)
+	if q == nil {
+		return
+	}
+
+	if page.Found {
+		result.Found = true
+		result.MatchedPages = append(result.MatchedPages, page.URL)
+	}
+	result.Count += page.Count
+	result.Truncated = result.Truncated || page.Truncated
+	result.StoppedEarly = result.StoppedEarly || page.StoppedEarly
+
+	// Keep the matched terms in the order they appear in the query.
+	matched := map[string]bool{}
+	for _, term := range append(result.Matched, page.Matched...) {
+		matched[term] = true
+	}
+	result.Matched = nil
+	for _, m := range q.leaves {
+		if matched[m.term] {
+			result.Matched = append(result.Matched, m.term)
+			delete(matched, m.term)
+		}
+	}
+
+	for _, r := range page.Regions {
+		if !containsRegion(result.Regions, r) {
+			result.Regions = append(result.Regions, r)
+		}
+	}
+	for _, snippet := range page.Snippets {
+		if len(result.Snippets) >= maxSnippets {
+			break
+		}
+		snippet.URL = page.URL
+		result.Snippets = append(result.Snippets, snippet)
+	}
+}
+
+// containsRegion reports whether a region is in a slice of regions.
+func containsRegion(regions []Region, region Region) bool {
+	for _, r := range regions {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package searcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestCrawlerSameSite(t *testing.T) {
+	tests := []struct {
+		first      string
+		sameDomain bool
+		url        string
+		want       bool
+	}{
+		{"https://example.com/", false, "https://example.com/about", true},
+		{"https://example.com/", false, "http://www.example.com/about", true},
+		{"https://www.example.com/", false, "https://example.com/about", true},
+		{"https://example.com/", false, "https://blog.example.com/", false},
+		{"https://example.com/", false, "https://example.org/", false},
+		{"https://example.com:8080/", false, "https://example.com/", false},
+
+		// With SameDomain, any host under the registrable domain is
+		// on the site, but not other domains under the same suffix.
+		{"https://www.example.com/", true, "https://blog.example.com/", true},
+		{"https://www.example.co.uk/", true, "https://shop.example.co.uk/", true},
+		{"https://www.example.co.uk/", true, "https://other.co.uk/", false},
+		{"https://a.github.io/", true, "https://b.github.io/", false},
+		{"http://127.0.0.1:8080/", true, "http://127.0.0.1:9090/", true},
+		{"http://localhost/", true, "http://localhost/a", true},
+	}
+	for _, tt := range tests {
+		first, _ := url.Parse(tt.first)
+		u, _ := url.Parse(tt.url)
+		c := newCrawler(CrawlOptions{Depth: 1, MaxPages: 10, SameDomain: tt.sameDomain}, first)
+		if got := c.sameSite(u); got != tt.want {
+			t.Errorf("sameSite(%q) from %q (same domain %v) = %v, want %v", tt.url, tt.first, tt.sameDomain, got, tt.want)
+		}
+	}
+}
+
+func TestCrawlerVisit(t *testing.T) {
+	first, _ := url.Parse("https://www.example.com/")
+	c := newCrawler(CrawlOptions{Depth: 1, MaxPages: 10}, first)
+
+	// Each page is visited once, whatever the scheme, 'www.' prefix
+	// or fragment of the link to it.
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.com/", false},
+		{"https://example.com/about", true},
+		{"http://www.example.com/about", false},
+		{"https://EXAMPLE.com/about", false},
+		{"https://example.com/about?a=1", true},
+		{"https://other.com/about", false},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		if got := c.visit(u); got != tt.want {
+			t.Errorf("visit(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestSearchCrawl(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("crawled %s on another site", r.URL)
+	}))
+	defer other.Close()
+
+	// The site's pages link to each other like this, with the needle
+	// on /d and /e.
+	pages := map[string]string{
+		"/":  `<a href="/a">a</a> <a href="b">b</a> <a href="/a#top">a</a> <a href="mailto:me@example.com">me</a> <a href="` + other.URL + `/x">x</a>`,
+		"/a": `<a href="/c">c</a> <a href="/">home</a>`,
+		"/b": `<a href="/d">d</a> <a href="/a">a</a>`,
+		"/c": `<a href="/e">e</a>`,
+		"/d": `needle`,
+		"/e": `needle`,
+	}
+	var mu sync.Mutex
+	var fetched []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched = append(fetched, r.URL.Path)
+		mu.Unlock()
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		crawl   CrawlOptions
+		fetched []string
+		matched []string
+	}{
+		{CrawlOptions{}, []string{"/"}, nil},
+		{CrawlOptions{Depth: 1}, []string{"/", "/a", "/b"}, nil},
+		{CrawlOptions{Depth: 2}, []string{"/", "/a", "/b", "/c", "/d"}, []string{"/d"}},
+		{CrawlOptions{Depth: 3}, []string{"/", "/a", "/b", "/c", "/d", "/e"}, []string{"/d", "/e"}},
+		{CrawlOptions{Depth: 10}, []string{"/", "/a", "/b", "/c", "/d", "/e"}, []string{"/d", "/e"}},
+
+		// MaxPages includes the site's page, and pages are fetched
+		// breadth-first.
+		{CrawlOptions{Depth: 3, MaxPages: 4}, []string{"/", "/a", "/b", "/c"}, nil},
+		{CrawlOptions{Depth: 3, MaxPages: 1}, []string{"/"}, nil},
+	}
+
+	for _, tt := range tests {
+		fetched = nil
+		s := New(Options{Crawl: tt.crawl, Limits: RateLimits{PerHost: 1}})
+		results, err := s.Search(context.Background(), []string{"needle"}, []string{server.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := results[0]
+
+		sort.Strings(fetched)
+		if !reflect.DeepEqual(fetched, tt.fetched) {
+			t.Errorf("%+v: fetched %v, want %v", tt.crawl, fetched, tt.fetched)
+		}
+
+		// Pages is only counted if the site was crawled.
+		wantPages := len(tt.fetched)
+		if tt.crawl.Depth == 0 || tt.crawl.MaxPages == 1 {
+			wantPages = 0
+		}
+		if r.Pages != wantPages {
+			t.Errorf("%+v: searched %d pages, want %d", tt.crawl, r.Pages, wantPages)
+		}
+		var matched []string
+		for _, page := range r.MatchedPages {
+			matched = append(matched, page[len(server.URL):])
+		}
+		if !reflect.DeepEqual(matched, tt.matched) || r.Found != (len(tt.matched) > 0) || r.Count != len(tt.matched) {
+			t.Errorf("%+v: found %v with %d matches on %v, want %v", tt.crawl, r.Found, r.Count, matched, tt.matched)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package searcher
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// ErrorKind classifies the errors recorded on results.
+type ErrorKind string
+
+const (
+	// ErrorURL means the site could not be parsed as a URL.
+	ErrorURL ErrorKind = "url"
+
+	// ErrorDNS means the host name could not be resolved.
+	ErrorDNS ErrorKind = "dns"
+
+	// ErrorConnect means the connection was refused or reset.
+	ErrorConnect ErrorKind = "connect"
+
+	// ErrorTimeout means the request timed out.
+	ErrorTimeout ErrorKind = "timeout"
+
+	// ErrorTLS means the TLS handshake or certificate verification failed.
+	ErrorTLS ErrorKind = "tls"
+
+	// ErrorHTTPStatus means the server responded with an error status code.
+	ErrorHTTPStatus ErrorKind = "http_status"
+
+	// ErrorBody means the response body could not be read or parsed.
+	ErrorBody ErrorKind = "body"
+
+	// ErrorCancelled means the search was cancelled before the site finished.
+	ErrorCancelled ErrorKind = "cancelled"
+
+	// ErrorOther is any other error.
+	ErrorOther ErrorKind = "other"
+)
+
+// Error is the error recorded on a result, classified by kind.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+// Error returns the error message prefixed with its kind.
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Kind, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the kind of err, or the empty string if err is nil.
+func KindOf(err error) ErrorKind {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, ErrCancelled) {
+		return ErrorCancelled
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return classify(err)
+}
+
+// classify takes an error returned by the http client and
+// returns its kind.
+func classify(err error) ErrorKind {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrorCancelled
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &verifyErr),
+		errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return ErrorTLS
+	case strings.Contains(err.Error(), "server gave HTTP response to HTTPS client"):
+		// net/http reports this without wrapping the underlying TLS error.
+		return ErrorTLS
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return ErrorConnect
+	}
+	return ErrorOther
+}
+
+// classifyError wraps err in an Error of the given kind, or of its
+// classified kind if kind is empty. A nil err is returned as nil.
+func classifyError(kind ErrorKind, err error) error {
+	if err == nil {
+		return nil
+	}
+	if kind == "" {
+		kind = classify(err)
+	}
+	return &Error{Kind: kind, Err: err}
+}
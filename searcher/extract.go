@@ -0,0 +1,486 @@
+package searcher
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// Target determines which part of each page is searched.
+type Target int
+
+const (
+	// TargetText searches the human-readable text of the page. This is the default.
+	TargetText Target = iota
+
+	// TargetRaw searches the raw HTML of the page.
+	TargetRaw
+
+	// TargetAttributes searches the attributes of each tag, written one
+	// tag per line as the tag name followed by name="value" pairs, e.g.
+	// `meta name="generator" content="WordPress"`.
+	TargetAttributes
+
+	// TargetScripts searches the src of each external script and the
+	// content of each inline script.
+	TargetScripts
+)
+
+// String returns the flag value for the target.
+func (t Target) String() string {
+	switch t {
+	case TargetText:
+		return "text"
+	case TargetRaw:
+		return "raw"
+	case TargetAttributes:
+		return "attributes"
+	case TargetScripts:
+		return "scripts"
+	}
+	return fmt.Sprintf("Target(%d)", int(t))
+}
+
+// ParseTarget takes the name of a target ("text", "raw", "attributes"
+// or "scripts") and returns the corresponding Target.
+func ParseTarget(s string) (Target, error) {
+	switch s {
+	case "text", "":
+		return TargetText, nil
+	case "raw":
+		return TargetRaw, nil
+	case "attributes":
+		return TargetAttributes, nil
+	case "scripts":
+		return TargetScripts, nil
+	}
+	return 0, fmt.Errorf("unknown target %q", s)
+}
+
+// skippedElements are the elements whose content is not part of the
+// page text.
+var skippedElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+	"template": true,
+}
+
+// blockElements are the elements that start a new line in the page text.
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"br": true, "dd": true, "div": true, "dl": true, "dt": true,
+	"fieldset": true, "figcaption": true, "figure": true, "footer": true,
+	"form": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "header": true, "hr": true, "li": true,
+	"main": true, "nav": true, "ol": true, "option": true, "p": true,
+	"pre": true, "section": true, "table": true, "td": true, "th": true,
+	"title": true, "tr": true, "ul": true,
+}
+
+// voidElements are the elements that have no end tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+// headingElements are the elements in RegionHeadings.
+var headingElements = map[string]bool{
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// textWriter receives the text extracted from a page.
+type textWriter interface {
+	// write writes some text.
+	write(text string)
+
+	// mark records that the text written next is in the given region.
+	mark(region Region)
+
+	// done reports whether the rest of the page is not needed.
+	done() bool
+}
+
+// extractor extracts the searched part of an HTML document as it is
+// tokenized, writing it to a textWriter, usually a stream.
+//
+// For TargetText, it writes the human-readable text in the selected
+// regions. Runs of whitespace are collapsed to a single space, block
+// elements are separated by newlines, and link targets follow the
+// link text in parentheses.
+type extractor struct {
+	z      *html.Tokenizer
+	out    textWriter
+	target Target
+
+	// links collects the targets of the page's links, if set.
+	links *links
+
+	// regions is the set of regions whose text is written, and
+	// selector selects the elements in RegionSelector, if set.
+	regions  regionSet
+	selector selector
+
+	// skip is the name of the skipped element being read, if any.
+	skip string
+
+	// inHead and inTitle report whether the head and title are
+	// being read, and headings counts the open heading elements.
+	inHead, inTitle bool
+	headings        int
+
+	// href is the target of the link being read, if any.
+	href string
+
+	// stack holds the open elements if there is a selector, and
+	// selected counts those matching it.
+	stack    []element
+	selected int
+
+	// space and newline record the separator owed before the next
+	// word, and empty whether any text has been written.
+	space, newline, empty bool
+
+	// region is the region of the last text written.
+	region Region
+}
+
+// extractText reads an HTML document from r and writes the part of
+// it searched in the given scope to out. If l is set, the targets of
+// the document's links are added to it. If stopEarly is set, it stops
+// reading once out is done and reports that it stopped.
+func extractText(r io.Reader, out textWriter, sc scope, l *links, stopEarly bool) (bool, error) {
+	if sc.target == TargetRaw {
+		if l != nil {
+			return copyRawLinks(r, out, l, stopEarly)
+		}
+		return copyRaw(r, out, stopEarly)
+	}
+
+	e := &extractor{
+		z:        html.NewTokenizer(r),
+		out:      out,
+		target:   sc.target,
+		links:    l,
+		selector: sc.selector,
+		empty:    true,
+	}
+	if sc.target == TargetText {
+		e.regions = sc.regions
+	}
+
+	for {
+		if stopEarly && out.done() {
+			return true, nil
+		}
+
+		switch e.z.Next() {
+		case html.ErrorToken:
+			if err := e.z.Err(); err != io.EOF {
+				return false, err
+			}
+			return false, nil
+
+		case html.TextToken:
+			switch {
+			case e.skip == "":
+				e.text(string(e.z.Text()), e.membership())
+			case e.skip == "script" && e.target == TargetScripts:
+				e.line(strings.TrimSpace(string(e.z.Text())))
+			}
+
+		case html.StartTagToken:
+			e.start(e.z.Token(), false)
+
+		case html.SelfClosingTagToken:
+			e.start(e.z.Token(), true)
+
+		case html.EndTagToken:
+			name, _ := e.z.TagName()
+			e.end(string(name))
+		}
+	}
+}
+
+// membership returns the set of regions that text at the
+// current position belongs to.
+func (e *extractor) membership() regionSet {
+	var set regionSet
+	switch {
+	case e.inTitle:
+		set = RegionTitle.bit()
+	case e.inHead:
+	default:
+		set = RegionBody.bit()
+		if e.headings > 0 {
+			set |= RegionHeadings.bit()
+		}
+		if e.href != "" {
+			set |= RegionLinks.bit()
+		}
+	}
+	if e.selected > 0 {
+		set |= RegionSelector.bit()
+	}
+	return set
+}
+
+// start handles a start tag.
+func (e *extractor) start(tok html.Token, selfClosing bool) {
+	tag := tok.Data
+	e.collect(tok)
+
+	switch {
+	case e.target == TargetAttributes && len(tok.Attr) > 0:
+		line := tag
+		for _, a := range tok.Attr {
+			line += fmt.Sprintf(" %s=%q", a.Key, a.Val)
+		}
+		e.line(line)
+
+	case e.target == TargetScripts && tag == "script":
+		e.line(attr(tok, "src"))
+	}
+
+	// The body ends the head even if it was not closed.
+	if tag == "body" {
+		e.inHead = false
+	}
+	if e.skip != "" {
+		return
+	}
+	if skippedElements[tag] && !selfClosing {
+		e.skip = tag
+		return
+	}
+	if blockElements[tag] {
+		e.newline = true
+	}
+	if e.selector != nil && !voidElements[tag] && !selfClosing {
+		e.push(tok)
+	}
+
+	switch {
+	case tag == "head":
+		e.inHead = true
+
+	case tag == "title":
+		e.inTitle = true
+
+	case headingElements[tag]:
+		e.headings++
+
+	case tag == "a":
+		e.href = strings.TrimPrefix(attr(tok, "href"), "mailto:")
+
+	case tag == "img":
+		// Images in links stand in for the link text.
+		set := RegionAlt.bit()
+		if e.href != "" {
+			set |= e.membership()
+		}
+		e.space = true
+		e.text(attr(tok, "alt"), set)
+
+	case tag == "meta":
+		switch strings.ToLower(attr(tok, "name")) {
+		case "description", "keywords":
+			e.newline = true
+			e.text(attr(tok, "content"), RegionMeta.bit())
+		}
+	}
+}
+
+// collect adds the target of a link or <base> tag to the links, if
+// they are being collected.
+func (e *extractor) collect(tok html.Token) {
+	if e.links == nil {
+		return
+	}
+	switch tok.Data {
+	case "a", "area":
+		e.links.add(attr(tok, "href"))
+	case "base":
+		e.links.setBase(attr(tok, "href"))
+	}
+}
+
+// end handles an end tag.
+func (e *extractor) end(tag string) {
+	if e.skip != "" {
+		if tag == e.skip {
+			e.skip = ""
+		}
+		return
+	}
+	if blockElements[tag] {
+		e.newline = true
+	}
+	if e.selector != nil {
+		e.pop(tag)
+	}
+
+	switch {
+	case tag == "head":
+		e.inHead = false
+
+	case tag == "title":
+		e.inTitle = false
+
+	case headingElements[tag] && e.headings > 0:
+		e.headings--
+
+	case tag == "a" && e.href != "":
+		e.space = true
+		e.text("( "+e.href+" )", e.membership())
+		e.href = ""
+	}
+}
+
+// push adds an element to the stack of open elements.
+func (e *extractor) push(tok html.Token) {
+	el := element{tag: tok.Data, attrs: map[string]string{}}
+	for _, a := range tok.Attr {
+		el.attrs[a.Key] = a.Val
+	}
+	e.stack = append(e.stack, el)
+
+	if e.selector.match(e.stack) {
+		e.stack[len(e.stack)-1].selected = true
+		e.selected++
+	}
+}
+
+// pop removes the most recently opened element named tag from the
+// stack, along with any elements opened after it that were not closed.
+// End tags with no open element are ignored.
+func (e *extractor) pop(tag string) {
+	for i := len(e.stack) - 1; i >= 0; i-- {
+		if e.stack[i].tag != tag {
+			continue
+		}
+		for _, el := range e.stack[i:] {
+			if el.selected {
+				e.selected--
+			}
+		}
+		e.stack = e.stack[:i]
+		return
+	}
+}
+
+// attr returns the value of the named attribute of a tag.
+func attr(tok html.Token, name string) string {
+	for _, a := range tok.Attr {
+		if a.Key == name {
+			return strings.TrimSpace(a.Val)
+		}
+	}
+	return ""
+}
+
+// line writes a line of text, if it is not empty.
+func (e *extractor) line(s string) {
+	if s != "" {
+		e.out.write(s)
+		e.out.write("\n")
+	}
+}
+
+// copyRaw copies a raw HTML document from r to out. If stopEarly is
+// set, it stops reading once out is done and reports that it stopped.
+func copyRaw(r io.Reader, out textWriter, stopEarly bool) (bool, error) {
+	buf := make([]byte, 4<<10)
+	for {
+		if stopEarly && out.done() {
+			return true, nil
+		}
+		n, err := r.Read(buf)
+		out.write(string(buf[:n]))
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// copyRawLinks is like copyRaw, but tokenizes the document as it is
+// copied so that the targets of its links are added to l.
+func copyRawLinks(r io.Reader, out textWriter, l *links, stopEarly bool) (bool, error) {
+	e := &extractor{z: html.NewTokenizer(r), links: l}
+	for {
+		if stopEarly && out.done() {
+			return true, nil
+		}
+
+		tt := e.z.Next()
+		out.write(string(e.z.Raw()))
+		switch tt {
+		case html.ErrorToken:
+			if err := e.z.Err(); err != io.EOF {
+				return false, err
+			}
+			return false, nil
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			e.collect(e.z.Token())
+		}
+	}
+}
+
+// text writes a text node in the given set of regions, collapsing
+// its whitespace. Text in adjacent inline elements is joined without
+// a separator, so a word split across tags is still matched. Text
+// not in a selected region is dropped.
+func (e *extractor) text(s string, set regionSet) {
+	if s == "" {
+		return
+	}
+	region := (set & e.regions).first()
+	if region == "" {
+		// Keep the words on either side apart.
+		e.space = true
+		return
+	}
+
+	if r, _ := utf8.DecodeRuneInString(s); unicode.IsSpace(r) {
+		e.space = true
+	}
+	for i, word := range strings.Fields(s) {
+		if i > 0 {
+			e.space = true
+		}
+		e.word(word, region)
+	}
+	if r, _ := utf8.DecodeLastRuneInString(s); unicode.IsSpace(r) {
+		e.space = true
+	}
+}
+
+// word writes a word in a region, preceded by any separator owed.
+// A change of region starts a new line.
+func (e *extractor) word(w string, region Region) {
+	if region != e.region {
+		e.newline = true
+	}
+	switch {
+	case e.empty:
+	case e.newline:
+		e.out.write("\n")
+	case e.space:
+		e.out.write(" ")
+	}
+	if region != e.region {
+		e.out.mark(region)
+		e.region = region
+	}
+	e.out.write(w)
+	e.space, e.newline, e.empty = false, false, false
+}
@@ -0,0 +1,158 @@
+package searcher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// textRecorder is a textWriter that records the text written to it,
+// and the regions marked, and is done once it holds limit bytes.
+type textRecorder struct {
+	b       strings.Builder
+	regions []Region
+	limit   int
+}
+
+func (r *textRecorder) write(text string) { r.b.WriteString(text) }
+func (r *textRecorder) mark(region Region) { r.regions = append(r.regions, region) }
+func (r *textRecorder) done() bool         { return r.limit > 0 && r.b.Len() >= r.limit }
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Target
+		err  bool
+	}{
+		{s: "", want: TargetText},
+		{s: "text", want: TargetText},
+		{s: "raw", want: TargetRaw},
+		{s: "attributes", want: TargetAttributes},
+		{s: "scripts", want: TargetScripts},
+		{s: "html", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTarget(tt.s)
+		if (err != nil) != tt.err || (!tt.err && got != tt.want) {
+			t.Errorf("ParseTarget(%q) = %v, %v; want %v (error %v)", tt.s, got, err, tt.want, tt.err)
+		}
+		if !tt.err && tt.s != "" && got.String() != tt.s {
+			t.Errorf("%v.String() = %q, want %q", got, got.String(), tt.s)
+		}
+	}
+}
+
+func TestExtractText(t *testing.T) {
+	const page = `<!DOCTYPE html>
+<html><head>
+<title>The  Title</title>
+<meta name="description" content="A description">
+<meta charset="utf-8">
+<style>body { color: red }</style>
+<script src="/app.js"></script>
+<script>var needle = "in a script";</script>
+</head>
+<body class="home">
+<h1>A <em>Heading</em></h1>
+<p>Some   text,
+split over lines, with un<b>break</b>able words.</p>
+<noscript>Enable scripts</noscript>
+<p>A <a href="/about">link</a> and <a href="mailto:me@example.com">an email</a>.</p>
+<ul><li>One<li>Two</ul>
+<img src="logo.png" alt="The logo">
+</body></html>`
+
+	tests := []struct {
+		target  Target
+		regions []Region
+		html    string
+		want    string
+		marks   []Region
+	}{
+		// The text target collapses whitespace, puts block elements
+		// on their own lines, and follows links with their targets.
+		// Image alt text is only in the body within links.
+		{
+			target: TargetText,
+			html:   page,
+			want: "A Heading\nSome text, split over lines, with unbreakable words.\n" +
+				"A link ( /about ) and an email ( me@example.com ).\nOne\nTwo",
+			marks: []Region{RegionBody},
+		},
+		{
+			target:  TargetText,
+			regions: []Region{RegionTitle, RegionMeta, RegionHeadings, RegionLinks},
+			html:    page,
+			want:    "The Title\nA description\nA Heading\nlink ( /about ) an email ( me@example.com )",
+			marks:   []Region{RegionTitle, RegionMeta, RegionHeadings, RegionLinks},
+		},
+		{
+			target:  TargetText,
+			regions: []Region{RegionAlt},
+			html:    page,
+			want:    "The logo",
+			marks:   []Region{RegionAlt},
+		},
+
+		// The raw target is the page as is.
+		{target: TargetRaw, html: page, want: page},
+
+		// The attributes target lists each tag with attributes.
+		{
+			target: TargetAttributes,
+			html:   page,
+			want: "meta name=\"description\" content=\"A description\"\nmeta charset=\"utf-8\"\n" +
+				"script src=\"/app.js\"\nbody class=\"home\"\na href=\"/about\"\na href=\"mailto:me@example.com\"\n" +
+				"img src=\"logo.png\" alt=\"The logo\"\n",
+		},
+		{target: TargetAttributes, html: `<p title="say &quot;hi&quot;">`, want: "p title=\"say \\\"hi\\\"\"\n"},
+
+		// The scripts target lists each script's src or content.
+		{target: TargetScripts, html: page, want: "/app.js\nvar needle = \"in a script\";\n"},
+		{target: TargetScripts, html: "<p>no scripts</p>", want: ""},
+	}
+
+	for _, tt := range tests {
+		sc, err := New(Options{Target: tt.target, Regions: tt.regions}).scope()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out textRecorder
+		stopped, err := extractText(strings.NewReader(tt.html), &out, sc, nil, false)
+		if err != nil || stopped {
+			t.Errorf("%v %v: extractText = %v, %v; want false, nil", tt.target, tt.regions, stopped, err)
+			continue
+		}
+		if got := out.b.String(); got != tt.want {
+			t.Errorf("%v %v: extracted %q, want %q", tt.target, tt.regions, got, tt.want)
+		}
+		if !reflect.DeepEqual(out.regions, tt.marks) {
+			t.Errorf("%v %v: marked regions %v, want %v", tt.target, tt.regions, out.regions, tt.marks)
+		}
+	}
+}
+
+func TestExtractTextStopsEarly(t *testing.T) {
+	html := strings.Repeat(`<p class="c">word <script>f()</script></p>`, 1000)
+	for _, target := range []Target{TargetText, TargetRaw, TargetAttributes, TargetScripts} {
+		sc, err := New(Options{Target: target}).scope()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Once the writer is done, reading stops, but only if asked.
+		for _, stopEarly := range []bool{false, true} {
+			out := textRecorder{limit: 1}
+			stopped, err := extractText(strings.NewReader(html), &out, sc, &links{}, stopEarly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stopped != stopEarly {
+				t.Errorf("%v: extractText(stop early %v) stopped %v", target, stopEarly, stopped)
+			}
+			if stopEarly && out.b.Len() >= len(html)/2 {
+				t.Errorf("%v: extracted %d bytes after stopping", target, out.b.Len())
+			}
+		}
+	}
+}
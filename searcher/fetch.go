@@ -0,0 +1,263 @@
+package searcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/timehop/golog/log"
+)
+
+// RetryPolicy determines how failed requests are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of requests made for each
+	// site, including the first and any made to fall back from HTTPS
+	// to HTTP or to the 'www' host prefix. Set to 1 to make a single
+	// request.
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry. The delay doubles
+	// with each further retry, with random jitter.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the delay between retries. If a server asks for a
+	// longer delay with Retry-After, the site is not retried.
+	MaxDelay time.Duration
+
+	// RetryOn lists the HTTP status codes that are retried.
+	RetryOn []int
+}
+
+// DefaultRetryPolicy is used for any unset fields of Options.Retry.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+	RetryOn:     []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+}
+
+// withDefaults returns the policy with any unset fields
+// taken from DefaultRetryPolicy.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	if p.RetryOn == nil {
+		p.RetryOn = DefaultRetryPolicy.RetryOn
+	}
+	return p
+}
+
+// retryOn reports whether the policy retries the status code.
+func (p RetryPolicy) retryOn(code int) bool {
+	for _, c := range p.RetryOn {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before the given retry (starting at 1):
+// the base delay doubled for each earlier retry, capped at the
+// maximum delay, with jitter of up to half the delay.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// fetch takes a normalized URL and whether its scheme was given
+// explicitly, and fetches it according to the retry policy. It
+// returns the response and the number of attempts made.
+//
+// Each attempt is a single request, so MaxAttempts caps the number of
+// requests made. The candidate URLs are tried in turn while requests
+// fail, such as when a connection times out or is refused, or the host
+// does not resolve, in which case the other candidates on the same
+// host are skipped. Once there are no more candidates, a timeout or a
+// refused or reset connection is retried on the last one, as is a
+// response whose status is in the policy's RetryOn list. Once the
+// attempts are exhausted, the last response is returned whatever its
+// status.
+func (s *Searcher) fetch(ctx context.Context, u *url.URL, explicit bool) (*http.Response, int, error) {
+	policy := s.opts.Retry
+	candidates := fetchURLs(u, explicit)
+	next := 0
+
+	for attempt := 1; ; attempt++ {
+		candidate := candidates[next]
+
+		// If robots.txt disallows the URL, skip the site altogether.
+		if s.robots != nil {
+			allowed, err := s.robotsAllowed(ctx, candidate)
+			if err != nil {
+				return nil, attempt, classifyError("", err)
+			}
+			if !allowed {
+				return nil, attempt, errDisallowed
+			}
+		}
+
+		response, err := s.get(ctx, candidate)
+		if errors.Is(err, errDisallowed) {
+			return nil, attempt, errDisallowed
+		}
+
+		// Work out whether the request should be retried, and after how long.
+		var delay time.Duration
+		retry := false
+		switch {
+		case ctx.Err() != nil:
+			if err == nil {
+				response.Body.Close()
+			}
+			return nil, attempt, classifyError(ErrorCancelled, ctx.Err())
+
+		case err != nil:
+			err = classifyError("", err)
+			kind := KindOf(err)
+			if attempt < policy.MaxAttempts {
+				if n := nextCandidate(candidates, next, kind); n >= 0 {
+					log.Debug("go-search", fmt.Sprintf("Request failed for %s, trying the next candidate.", candidate), "error", err)
+					next = n
+					continue
+				}
+			}
+			retry = kind == ErrorTimeout || kind == ErrorConnect
+			delay = policy.backoff(attempt)
+
+		case policy.retryOn(response.StatusCode):
+			retry = attempt < policy.MaxAttempts
+			delay = policy.backoff(attempt)
+
+			// Honor Retry-After, unless the server asks us to wait too long.
+			if after, ok := retryAfter(response); ok {
+				if after > policy.MaxDelay {
+					log.Debug("go-search", fmt.Sprintf("Not retrying %s: Retry-After of %s exceeds the maximum delay", u, after))
+					retry = false
+				} else if after > delay {
+					delay = after
+				}
+			}
+
+			// If the response won't be retried, return it so its
+			// status is recorded like any other response.
+			if !retry {
+				return response, attempt, nil
+			}
+			err = fmt.Errorf("%s from %s", response.Status, response.Request.URL)
+
+			// Drain and close the body so the connection can be reused.
+			io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+			response.Body.Close()
+		}
+
+		if err == nil {
+			return response, attempt, nil
+		}
+		if !retry || attempt >= policy.MaxAttempts {
+			return nil, attempt, err
+		}
+
+		log.Debug("go-search", fmt.Sprintf("Attempt %d failed for %s, retrying in %s.", attempt, candidate, delay), "error", err)
+
+		// Wait before retrying, unless the search is cancelled.
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, attempt, classifyError(ErrorCancelled, ctx.Err())
+		}
+	}
+}
+
+// nextCandidate takes the candidate URLs, the index of one whose
+// request failed with an error of the given kind, and returns the
+// index of the next candidate to try, or -1 if there is none. If the
+// host did not resolve, the other candidates on it are skipped.
+func nextCandidate(candidates []string, failed int, kind ErrorKind) int {
+	var host string
+	if u, err := url.Parse(candidates[failed]); err == nil && kind == ErrorDNS {
+		host = u.Host
+	}
+	for i := failed + 1; i < len(candidates); i++ {
+		if u, err := url.Parse(candidates[i]); err == nil && host != "" && u.Host == host {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// retryAfter parses the Retry-After header of a response, which may
+// be a number of seconds or an HTTP date.
+func retryAfter(response *http.Response) (time.Duration, bool) {
+	v := response.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// get issues a GET request for url bound to ctx, with the configured
+// User-Agent and extra headers. If there are rate limits, it first
+// waits for the limiter, and the request counts as in flight until
+// the response body is closed. The wait happens before the request
+// is sent so it does not count towards the client's timeout. Requests
+// served from the cache or a WARC archive without contacting the server
+// are not limited.
+//
+// Requests for robots.txt files don't take a per-host slot: the file
+// for a redirect's target is fetched while the redirected request
+// holds its slot, which may be the target host's only one.
+func (s *Searcher) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.opts.Client.setHeaders(req)
+	if s.limiter == nil || s.opts.Client.Archive != nil || (s.opts.Client.Cache != nil && s.opts.Client.Cache.fresh(req.URL.String())) {
+		return s.client.Do(req)
+	}
+
+	release, err := s.limiter.acquire(ctx, req.URL.Hostname(), ctx.Value(robotsFetchKey{}) == nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := s.client.Do(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	response.Body = &releasingBody{ReadCloser: response.Body, release: release}
+	return response, nil
+}
@@ -0,0 +1,193 @@
+package searcher
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchRetriesTimeouts(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	s := New(Options{
+		Timeout: 100 * time.Millisecond,
+		Retry:   RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+	})
+
+	// Without a scheme, HTTPS is tried first and fails quickly against
+	// the plain HTTP server, and then HTTP times out and is retried.
+	u, explicit, err := NormalizeURL(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, attempts, err := s.fetch(context.Background(), u, explicit)
+	if KindOf(err) != ErrorTimeout {
+		t.Errorf("fetch returned %v, want a timeout", err)
+	}
+	if attempts != 3 {
+		t.Errorf("fetch made %d attempts, want 3", attempts)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("server received %d requests, want 2", n)
+	}
+}
+
+// tlsHangListener is a listener whose TLS connections hang, so that
+// HTTPS requests to it time out while HTTP requests are served.
+type tlsHangListener struct {
+	net.Listener
+
+	mu   sync.Mutex
+	hung []net.Conn
+}
+
+// Accept returns the next connection that doesn't start with a TLS
+// handshake record.
+func (l *tlsHangListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		br := bufio.NewReader(c)
+		if b, err := br.Peek(1); err == nil && b[0] == 0x16 {
+			l.mu.Lock()
+			l.hung = append(l.hung, c)
+			l.mu.Unlock()
+			continue
+		}
+		return peekedConn{Conn: c, r: br}, nil
+	}
+}
+
+// Close closes the listener and the hung connections.
+func (l *tlsHangListener) Close() error {
+	l.mu.Lock()
+	for _, c := range l.hung {
+		c.Close()
+	}
+	l.mu.Unlock()
+	return l.Listener.Close()
+}
+
+// peekedConn is a connection whose first bytes have been peeked.
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func TestFetchFallsBackAfterTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &tlsHangListener{Listener: ln}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})}
+	go server.Serve(l)
+	defer server.Close()
+
+	tests := []struct {
+		maxAttempts  int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{maxAttempts: 3, wantAttempts: 2},
+		{maxAttempts: 1, wantAttempts: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		// HTTPS hangs until it times out, and HTTP is tried next.
+		s := New(Options{
+			Timeout: 200 * time.Millisecond,
+			Retry:   RetryPolicy{MaxAttempts: tt.maxAttempts, BaseDelay: time.Millisecond},
+		})
+		u, explicit, err := NormalizeURL(ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, attempts, err := s.fetch(context.Background(), u, explicit)
+		if err == nil {
+			if response.Request.URL.Scheme != "http" {
+				t.Errorf("fetched %s, want the HTTP URL", response.Request.URL)
+			}
+			response.Body.Close()
+		} else if KindOf(err) != ErrorTimeout {
+			t.Errorf("with %d attempts, fetch returned %v, want a timeout", tt.maxAttempts, err)
+		}
+		if (err != nil) != tt.wantErr || attempts != tt.wantAttempts {
+			t.Errorf("with %d attempts, fetch made %d attempts and returned %v, want %d attempts", tt.maxAttempts, attempts, err, tt.wantAttempts)
+		}
+	}
+}
+
+func TestFetchFallsBack(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		maxAttempts  int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{maxAttempts: 3, wantAttempts: 2},
+		{maxAttempts: 2, wantAttempts: 2},
+		{maxAttempts: 1, wantAttempts: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		s := New(Options{Retry: RetryPolicy{MaxAttempts: tt.maxAttempts, BaseDelay: time.Millisecond}})
+		u, explicit, err := NormalizeURL(strings.TrimPrefix(server.URL, "http://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, attempts, err := s.fetch(context.Background(), u, explicit)
+		if err == nil {
+			response.Body.Close()
+		}
+		if (err != nil) != tt.wantErr || attempts != tt.wantAttempts {
+			t.Errorf("with %d attempts, fetch made %d attempts and returned %v, want %d attempts", tt.maxAttempts, attempts, err, tt.wantAttempts)
+		}
+	}
+}
+
+func TestNextCandidate(t *testing.T) {
+	candidates := fetchURLs(&url.URL{Scheme: "https", Host: "example.com", Path: "/"}, false)
+	tests := []struct {
+		failed int
+		kind   ErrorKind
+		want   int
+	}{
+		{0, ErrorTLS, 1},
+		{0, ErrorDNS, 2},
+		{1, ErrorConnect, 2},
+		{2, ErrorDNS, -1},
+		{3, ErrorConnect, -1},
+	}
+	for _, tt := range tests {
+		if got := nextCandidate(candidates, tt.failed, tt.kind); got != tt.want {
+			t.Errorf("nextCandidate(%d, %s) = %d, want %d", tt.failed, tt.kind, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package searcher
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalization determines the Unicode normalization form applied to
+// page text and search terms before they are compared.
+type Normalization int
+
+const (
+	// NormalizeNFKC applies compatibility composition, so that
+	// compatibility characters such as full-width letters and
+	// ligatures match their plain equivalents. This is the default.
+	NormalizeNFKC Normalization = iota
+
+	// NormalizeNFC applies canonical composition, so that composed
+	// and decomposed accents match, but otherwise leaves the text as is.
+	NormalizeNFC
+)
+
+// String returns the flag value for the normalization form.
+func (n Normalization) String() string {
+	switch n {
+	case NormalizeNFKC:
+		return "nfkc"
+	case NormalizeNFC:
+		return "nfc"
+	}
+	return fmt.Sprintf("Normalization(%d)", int(n))
+}
+
+// ParseNormalization takes the name of a normalization form ("nfkc"
+// or "nfc") and returns the corresponding Normalization.
+func ParseNormalization(s string) (Normalization, error) {
+	switch strings.ToLower(s) {
+	case "nfkc", "":
+		return NormalizeNFKC, nil
+	case "nfc":
+		return NormalizeNFC, nil
+	}
+	return 0, fmt.Errorf("unknown normalization form %q", s)
+}
+
+// folder folds text for case- and normalization-insensitive comparison.
+type folder struct {
+	form norm.Form
+
+	// ignoreAccents removes combining marks after decomposing the text.
+	ignoreAccents bool
+}
+
+// newFolder returns a folder for the normalization form, which
+// decomposes the text instead if accents are to be ignored.
+func newFolder(n Normalization, ignoreAccents bool) folder {
+	form := norm.NFKC
+	switch {
+	case n == NormalizeNFC && ignoreAccents:
+		form = norm.NFD
+	case n == NormalizeNFC:
+		form = norm.NFC
+	case ignoreAccents:
+		form = norm.NFKD
+	}
+	return folder{form: form, ignoreAccents: ignoreAccents}
+}
+
+// fold takes some text and returns it normalized and case folded,
+// along with a slice mapping each byte offset in the folded text to
+// the byte offset in the original text it came from. The slice has
+// an extra entry mapping the end of the folded text, and is nil if
+// every offset is unchanged (e.g. for ASCII text).
+//
+// The text is folded one normalization segment (a character and any
+// combining marks) at a time, so every offset maps to the start of
+// a segment in the original text.
+func (f folder) fold(text string) (string, []int) {
+	caser := cases.Fold()
+
+	var b strings.Builder
+	b.Grow(len(text))
+	var offsets []int
+
+	var it norm.Iter
+	it.InitString(f.form, text)
+	for !it.Done() {
+		start := it.Pos()
+		segment := it.Next()
+		n := b.Len()
+
+		if len(segment) == 1 && segment[0] < utf8.RuneSelf {
+			// Most text is ASCII, so fold it directly.
+			c := segment[0]
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			b.WriteByte(c)
+		} else {
+			// Full case folding maps the Turkish dotted 'İ' to "i̇";
+			// drop the dot so it matches a plain 'i'.
+			folded := strings.ReplaceAll(caser.String(string(segment)), "i̇", "i")
+			for _, r := range folded {
+				if f.ignoreAccents && unicode.Is(unicode.Mn, r) {
+					continue
+				}
+				b.WriteRune(r)
+			}
+		}
+
+		// Only build the offsets once a segment changes, as until
+		// then they are the same as the original offsets.
+		if offsets == nil && b.String()[n:] == text[start:it.Pos()] {
+			continue
+		}
+		if offsets == nil {
+			offsets = make([]int, n, len(text)+1)
+			for j := range offsets {
+				offsets[j] = j
+			}
+		}
+		for j := n; j < b.Len(); j++ {
+			offsets = append(offsets, start)
+		}
+	}
+	if offsets != nil {
+		offsets = append(offsets, len(text))
+	}
+
+	return b.String(), offsets
+}
@@ -0,0 +1,106 @@
+package searcher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseNormalization(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Normalization
+		err  bool
+	}{
+		{s: "", want: NormalizeNFKC},
+		{s: "nfkc", want: NormalizeNFKC},
+		{s: "NFC", want: NormalizeNFC},
+		{s: "nfd", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseNormalization(tt.s)
+		if (err != nil) != tt.err || (!tt.err && got != tt.want) {
+			t.Errorf("ParseNormalization(%q) = %v, %v; want %v (error %v)", tt.s, got, err, tt.want, tt.err)
+		}
+		if !tt.err && tt.s != "" && got.String() != strings.ToLower(tt.s) {
+			t.Errorf("%v.String() = %q, want %q", got, got.String(), strings.ToLower(tt.s))
+		}
+	}
+}
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		text    string
+		form    Normalization
+		accents bool
+		want    string
+		offsets []int
+	}{
+		// Offsets are only mapped if folding changes them.
+		{text: "hello world", want: "hello world"},
+		{text: "café 日本", want: "café 日本"},
+		{text: "Hello", want: "hello", offsets: []int{0, 1, 2, 3, 4, 5}},
+
+		// Each byte folded from a character maps to its start.
+		{text: "ﬁle", want: "file", offsets: []int{0, 0, 3, 4, 5}},
+		{text: "ＦＯＸ!", want: "fox!", offsets: []int{0, 3, 6, 9, 10}},
+		{text: "Straße", want: "strasse", offsets: []int{0, 1, 2, 3, 4, 4, 6, 7}},
+		{text: "İstanbul", want: "istanbul", offsets: []int{0, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{text: "ab²", want: "ab2", offsets: []int{0, 1, 2, 4}},
+		{text: "ab²", form: NormalizeNFC, want: "ab²"},
+
+		// Combining marks map to the character they combine with.
+		{text: "cafe\u0301", want: "café", offsets: []int{0, 1, 2, 3, 3, 6}},
+		{text: "cafe\u0301", form: NormalizeNFC, want: "café", offsets: []int{0, 1, 2, 3, 3, 6}},
+		{text: "café!", accents: true, want: "cafe!", offsets: []int{0, 1, 2, 3, 5, 6}},
+		{text: "cafe\u0301!", accents: true, want: "cafe!", offsets: []int{0, 1, 2, 3, 6, 7}},
+		{text: "ÉCOLE", form: NormalizeNFC, accents: true, want: "ecole", offsets: []int{0, 2, 3, 4, 5, 6}},
+	}
+
+	for _, tt := range tests {
+		f := newFolder(tt.form, tt.accents)
+		got, offsets := f.fold(tt.text)
+		if got != tt.want || !reflect.DeepEqual(offsets, tt.offsets) {
+			t.Errorf("%v (accents %v) fold(%q) = %q, %v; want %q, %v", tt.form, tt.accents, tt.text, got, offsets, tt.want, tt.offsets)
+		}
+	}
+}
+
+func TestPageOffsets(t *testing.T) {
+	tests := []struct {
+		text string
+
+		// Each occurrence of term in the folded text must map back to
+		// the corresponding original text.
+		term string
+		want []string
+	}{
+		{"the quick brown fox", "fox", []string{"fox"}},
+		{"ＴＨＥ ＦＯＸ, the fox", "fox", []string{"ＦＯＸ", "fox"}},
+		{"ﬁnd the ﬁle, ﬁfi", "fi", []string{"ﬁ", "ﬁ", "ﬁ", "fi"}},
+		{"STRASSE, Straße", "strasse", []string{"STRASSE", "Straße"}},
+		{"cafe\u0301 café", "café", []string{"cafe\u0301", "café"}},
+	}
+
+	for _, tt := range tests {
+		p := newPage(tt.text, newFolder(NormalizeNFKC, false))
+		var got []string
+		for i := 0; ; {
+			j := strings.Index(p.folded[i:], tt.term)
+			if j < 0 {
+				break
+			}
+			start, end := i+j, i+j+len(tt.term)
+			got = append(got, tt.text[p.origin(start):p.origin(end)])
+
+			// The position of the original start is the folded start.
+			if pos := p.position(p.origin(start)); pos != start {
+				t.Errorf("%q: position(%d) = %d, want %d", tt.text, p.origin(start), pos, start)
+			}
+			i = end
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q in %q maps back to %q, want %q", tt.term, tt.text, got, tt.want)
+		}
+	}
+}
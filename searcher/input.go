@@ -0,0 +1,334 @@
+package searcher
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/timehop/golog/log"
+)
+
+// InputOptions configures how a file of URLs is read.
+type InputOptions struct {
+	// URLColumn selects the column containing URLs, either by 1-based
+	// index (e.g. "2") or by header name (e.g. "URL"). If empty, the
+	// column is chosen automatically: a column with a header such as
+	// "URL" or "Site", or else the first column containing URLs.
+	URLColumn string
+
+	// Columns selects additional columns to carry through to each
+	// result, by 1-based index or by header name.
+	Columns []string
+}
+
+// Record is a single row of input: a URL to search and any
+// columns carried through from the input file.
+type Record struct {
+	URL     string
+	Columns []Column
+
+	// Line is the line number of the record in the input file.
+	Line int
+}
+
+// Column is a named value carried through from the input file.
+type Column struct {
+	// Name is the column's header name, or "column N"
+	// if the input has no header row.
+	Name  string
+	Value string
+}
+
+// urlHeaders are the header names recognised as the URL column,
+// in order of preference.
+var urlHeaders = []string{"url", "urls", "site", "website", "domain", "host", "link"}
+
+// ReadFile takes the path of a file of URLs and returns a slice of
+// records. The file may be a CSV or TSV file with or without a header
+// row, or a plain list with one URL per line. Records whose URLs
+// normalize to the same URL as an earlier record are skipped.
+func ReadFile(path string, opts InputOptions) ([]Record, error) {
+
+	log.Info("go-search", "Reading from the input file")
+
+	// Open the file.
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Treat files with a .tsv extension as tab-separated
+	// regardless of their content.
+	delim := rune(0)
+	if strings.EqualFold(filepath.Ext(path), ".tsv") {
+		delim = '\t'
+	}
+
+	return readRecords(f, delim, opts)
+}
+
+// ReadRecords takes a reader of CSV, TSV or plain newline-separated
+// URLs and returns a slice of records. See ReadFile.
+func ReadRecords(r io.Reader, opts InputOptions) ([]Record, error) {
+	return readRecords(r, 0, opts)
+}
+
+// readRecords reads records from r. If delim is zero, the format
+// is detected from the first non-blank line.
+func readRecords(r io.Reader, delim rune, opts InputOptions) ([]Record, error) {
+	br := bufio.NewReader(r)
+
+	// Detect the format: a tab means TSV, a comma means CSV,
+	// and anything else is a plain list of URLs.
+	if delim == 0 {
+		first, err := firstLine(br)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case strings.Contains(first, "\t"):
+			delim = '\t'
+		case strings.Contains(first, ","):
+			delim = ','
+		default:
+			return readList(br, opts)
+		}
+	}
+
+	// Read the rows, allowing a variable number of fields per row
+	// so that short rows can be reported with their line number.
+	cr := csv.NewReader(br)
+	cr.Comma = delim
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = delim == '\t'
+
+	var rows [][]string
+	var lines []int
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		rows = append(rows, row)
+		lines = append(lines, line)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	// Choose the URL column and work out whether the first row is a header.
+	col, header, err := urlColumn(rows, opts.URLColumn)
+	if err != nil {
+		return nil, err
+	}
+	if header {
+		log.Debug("go-search", fmt.Sprintf("Detected header row, reading URLs from column %d (%q)", col+1, rows[0][col]))
+	} else {
+		log.Debug("go-search", fmt.Sprintf("No header row detected, reading URLs from column %d", col+1))
+	}
+
+	// Resolve the columns to carry through to each result.
+	carried, names, err := carriedColumns(rows[0], header, opts.Columns)
+	if err != nil {
+		return nil, err
+	}
+	if header {
+		rows, lines = rows[1:], lines[1:]
+	}
+
+	// Construct a slice of records.
+	var records []Record
+	for i, row := range rows {
+		if col >= len(row) {
+			return nil, fmt.Errorf("line %d: row has %d column(s) but the URL column is column %d", lines[i], len(row), col+1)
+		}
+		site := strings.TrimSpace(row[col])
+		if site == "" {
+			log.Warn("go-search", fmt.Sprintf("Skipping line %d: no URL in column %d", lines[i], col+1))
+			continue
+		}
+
+		record := Record{URL: site, Line: lines[i]}
+		for j, c := range carried {
+			if c >= len(row) {
+				return nil, fmt.Errorf("line %d: row has %d column(s) but column %d (%q) was requested", lines[i], len(row), c+1, names[j])
+			}
+			record.Columns = append(record.Columns, Column{Name: names[j], Value: row[c]})
+		}
+		records = append(records, record)
+	}
+
+	return dedupe(records), nil
+}
+
+// carriedColumns takes the first row of the input, whether it is a
+// header, and the requested columns, and returns the 0-based index
+// and name of each column to carry through to the results.
+func carriedColumns(first []string, header bool, requested []string) ([]int, []string, error) {
+	var indexes []int
+	var names []string
+
+	for _, req := range requested {
+		col := -1
+		if n, err := strconv.Atoi(req); err == nil {
+			if n < 1 {
+				return nil, nil, fmt.Errorf("invalid column %d: columns are numbered from 1", n)
+			}
+			col = n - 1
+		} else if header {
+			for i, name := range first {
+				if strings.EqualFold(strings.TrimSpace(name), req) {
+					col = i
+					break
+				}
+			}
+		}
+		if col < 0 {
+			return nil, nil, fmt.Errorf("no column named %q in the header row", req)
+		}
+
+		// Name the column after its header, if there is one.
+		name := fmt.Sprintf("column %d", col+1)
+		if header && col < len(first) {
+			name = strings.TrimSpace(first[col])
+		}
+		indexes = append(indexes, col)
+		names = append(names, name)
+	}
+
+	return indexes, names, nil
+}
+
+// readList reads a plain list of URLs, one per line. Blank lines
+// are skipped, as is a first line naming the column, such as "URL"
+// or "Site". Any other first line is kept, even if it does not look
+// like a URL (e.g. "intranet" or "localhost:8080").
+func readList(br *bufio.Reader, opts InputOptions) ([]Record, error) {
+	if opts.URLColumn != "" && opts.URLColumn != "1" {
+		return nil, fmt.Errorf("URL column %q requested but the input is a plain list of URLs", opts.URLColumn)
+	}
+	if len(opts.Columns) > 0 {
+		return nil, fmt.Errorf("columns requested but the input is a plain list of URLs")
+	}
+
+	var records []Record
+	s := bufio.NewScanner(br)
+	for line := 1; s.Scan(); line++ {
+		site := strings.TrimSpace(s.Text())
+		if site == "" {
+			continue
+		}
+		if len(records) == 0 && isURLHeader(site) {
+			log.Debug("go-search", fmt.Sprintf("Skipping header line %d: %q", line, site))
+			continue
+		}
+		records = append(records, Record{URL: site, Line: line})
+	}
+
+	return dedupe(records), s.Err()
+}
+
+// isURLHeader reports whether s is one of the urlHeaders.
+func isURLHeader(s string) bool {
+	for _, name := range urlHeaders {
+		if strings.EqualFold(strings.TrimSpace(s), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstLine returns the first non-blank line of br without consuming it.
+func firstLine(br *bufio.Reader) (string, error) {
+	for n := 512; ; n *= 2 {
+		b, err := br.Peek(n)
+		for _, line := range bytes.Split(b, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				return string(line), nil
+			}
+		}
+		if err == io.EOF || err == bufio.ErrBufferFull {
+			return string(b), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// urlColumn takes the rows of a CSV file and the requested URL
+// column, and returns the 0-based index of the URL column and
+// whether the first row is a header.
+func urlColumn(rows [][]string, requested string) (int, bool, error) {
+	first := rows[0]
+
+	// A column requested by index.
+	if n, err := strconv.Atoi(requested); err == nil {
+		if n < 1 {
+			return 0, false, fmt.Errorf("invalid URL column %d: columns are numbered from 1", n)
+		}
+		col := n - 1
+		header := col < len(first) && !looksLikeURL(first[col])
+		return col, header, nil
+	}
+
+	// A column requested by header name.
+	if requested != "" {
+		for i, name := range first {
+			if strings.EqualFold(strings.TrimSpace(name), requested) {
+				return i, true, nil
+			}
+		}
+		return 0, false, fmt.Errorf("no column named %q in the header row", requested)
+	}
+
+	// Otherwise look for a recognised header name.
+	for _, want := range urlHeaders {
+		for i, name := range first {
+			if strings.EqualFold(strings.TrimSpace(name), want) {
+				return i, true, nil
+			}
+		}
+	}
+
+	// Failing that, take the first column that contains a URL in the
+	// first row, or in the second row if the first is an unrecognised header.
+	for _, row := range rows[:min(2, len(rows))] {
+		for i, cell := range row {
+			if looksLikeURL(cell) {
+				header := i < len(first) && !looksLikeURL(first[i])
+				return i, header, nil
+			}
+		}
+	}
+
+	return 0, false, fmt.Errorf("could not find a column of URLs, use the URL column option to select one")
+}
+
+// looksLikeURL reports whether s looks like a URL or a host name
+// (e.g. "https://example.com/" or "example.com/") rather than a
+// header name or a number.
+func looksLikeURL(s string) bool {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.ContainsAny(s, " \t") {
+		return false
+	}
+	if hasScheme(s) {
+		return true
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return false
+	}
+	return strings.Contains(s, ".")
+}
@@ -0,0 +1,37 @@
+package searcher
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadList(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"example.com\nexample.org\n", []string{"example.com", "example.org"}},
+		{"URL\nexample.com\n", []string{"example.com"}},
+		{"\n  site \nexample.com\n", []string{"example.com"}},
+		{"intranet\nexample.com\n", []string{"intranet", "example.com"}},
+		{"localhost:8080\nexample.com\n", []string{"localhost:8080", "example.com"}},
+		{"example.com\nURL\n", []string{"example.com", "URL"}},
+	}
+
+	for _, tt := range tests {
+		records, err := readList(bufio.NewReader(strings.NewReader(tt.input)), InputOptions{})
+		if err != nil {
+			t.Errorf("readList(%q) failed: %v", tt.input, err)
+			continue
+		}
+		var got []string
+		for _, r := range records {
+			got = append(got, r.URL)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("readList(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
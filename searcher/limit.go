@@ -0,0 +1,158 @@
+package searcher
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimits controls how quickly requests are made. The zero value
+// applies no limits beyond Options.MaxRequests.
+type RateLimits struct {
+	// PerHost is the maximum number of concurrent requests to a
+	// single host. If zero, there is no per-host limit.
+	PerHost int
+
+	// HostDelay is the minimum delay between the start of
+	// consecutive requests to a single host.
+	HostDelay time.Duration
+
+	// RequestsPerSecond caps the rate of requests across all
+	// hosts. If zero, there is no global limit.
+	RequestsPerSecond float64
+}
+
+// enabled reports whether any limits are set.
+func (l RateLimits) enabled() bool {
+	return l.PerHost > 0 || l.HostDelay > 0 || l.RequestsPerSecond > 0
+}
+
+// limiter enforces RateLimits. It is safe for concurrent use.
+type limiter struct {
+	limits RateLimits
+
+	mu    sync.Mutex
+	hosts map[string]*hostLimit
+
+	// next is the earliest time the next request may start
+	// under the global rate limit.
+	next time.Time
+}
+
+// hostLimit tracks the requests made to a single host.
+type hostLimit struct {
+	// slots holds a token for each request in flight, if
+	// there is a per-host concurrency limit.
+	slots chan struct{}
+
+	// next is the earliest time the next request to the host may start.
+	next time.Time
+
+	// delay overrides RateLimits.HostDelay for the host, if longer.
+	delay time.Duration
+}
+
+// newLimiter returns a limiter enforcing the given limits.
+func newLimiter(limits RateLimits) *limiter {
+	return &limiter{
+		limits: limits,
+		hosts:  map[string]*hostLimit{},
+	}
+}
+
+// acquire waits until a request to host is allowed to start under
+// the limits, and returns a function to call once the request is
+// finished. Unless slot is set, the request only waits for its start
+// time, and doesn't count towards the per-host concurrency limit. It
+// returns an error if ctx is done first.
+func (l *limiter) acquire(ctx context.Context, host string, slot bool) (func(), error) {
+	l.mu.Lock()
+	h := l.host(host)
+	l.mu.Unlock()
+
+	// Wait for a free slot for the host.
+	release := func() {}
+	if h.slots != nil && slot {
+		select {
+		case h.slots <- struct{}{}:
+			release = func() { <-h.slots }
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// Reserve the next start time for the host and under the global
+	// rate limit, then wait until the later of the two.
+	now := time.Now()
+	l.mu.Lock()
+	start := now
+	if h.next.After(start) {
+		start = h.next
+	}
+	delay := l.limits.HostDelay
+	if h.delay > delay {
+		delay = h.delay
+	}
+	h.next = start.Add(delay)
+	if l.limits.RequestsPerSecond > 0 {
+		if l.next.After(start) {
+			start = l.next
+		}
+		l.next = start.Add(time.Duration(float64(time.Second) / l.limits.RequestsPerSecond))
+	}
+	l.mu.Unlock()
+
+	if wait := start.Sub(now); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			release()
+			return nil, ctx.Err()
+		}
+	}
+
+	return release, nil
+}
+
+// setHostDelay sets the minimum delay between requests to host,
+// if it is longer than RateLimits.HostDelay (e.g. a robots.txt Crawl-delay).
+func (l *limiter) setHostDelay(host string, delay time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.host(host).delay = delay
+}
+
+// host returns the hostLimit for host, creating it if needed.
+// The caller must hold l.mu.
+func (l *limiter) host(host string) *hostLimit {
+	host = strings.TrimPrefix(strings.ToLower(host), "www.")
+
+	h, ok := l.hosts[host]
+	if !ok {
+		h = &hostLimit{}
+		if l.limits.PerHost > 0 {
+			h.slots = make(chan struct{}, l.limits.PerHost)
+		}
+		l.hosts[host] = h
+	}
+	return h
+}
+
+// releasingBody is a response body that releases its
+// limiter slot when it is closed.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+// Close closes the body and releases the limiter slot.
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
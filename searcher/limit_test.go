@@ -0,0 +1,182 @@
+package searcher
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	const d = 30 * time.Millisecond
+	tests := []struct {
+		name   string
+		limits RateLimits
+		hosts  []string
+		slot   bool
+		hold   time.Duration
+
+		// crawlDelay is passed to setHostDelay for each host, if set.
+		crawlDelay time.Duration
+
+		// The requests must take at least min, and less than max
+		// if it is set, with at most concurrent in flight at once.
+		min, max   time.Duration
+		concurrent int
+	}{
+		{
+			name:  "no limits",
+			hosts: []string{"a.com", "a.com", "a.com", "a.com"},
+			slot:  true, hold: d,
+			min: d, max: 3 * d, concurrent: 4,
+		},
+		{
+			name:   "host delay",
+			limits: RateLimits{HostDelay: d},
+			hosts:  []string{"a.com", "a.com", "a.com", "a.com"},
+			slot:   true,
+			min:    3 * d, concurrent: 4,
+		},
+		{
+			name:   "host delay across hosts",
+			limits: RateLimits{HostDelay: d},
+			hosts:  []string{"a.com", "b.com", "c.com", "d.com"},
+			slot:   true,
+			max:    2 * d, concurrent: 4,
+		},
+		{
+			name:   "www. shares the host's delay",
+			limits: RateLimits{HostDelay: d},
+			hosts:  []string{"a.com", "www.a.com", "WWW.A.COM"},
+			slot:   true,
+			min:    2 * d, concurrent: 3,
+		},
+		{
+			name:   "requests per second",
+			limits: RateLimits{RequestsPerSecond: float64(time.Second / d)},
+			hosts:  []string{"a.com", "b.com", "c.com", "d.com"},
+			slot:   true,
+			min:    3 * d, concurrent: 4,
+		},
+		{
+			name:   "crawl delay",
+			limits: RateLimits{HostDelay: d / 3},
+			hosts:  []string{"a.com", "a.com", "a.com"},
+			slot:   true, crawlDelay: d,
+			min: 2 * d, concurrent: 3,
+		},
+		{
+			name:   "shorter crawl delay",
+			limits: RateLimits{HostDelay: d},
+			hosts:  []string{"a.com", "a.com", "a.com"},
+			slot:   true, crawlDelay: d / 3,
+			min: 2 * d, concurrent: 3,
+		},
+		{
+			name:   "per host",
+			limits: RateLimits{PerHost: 2},
+			hosts:  []string{"a.com", "a.com", "a.com", "a.com", "a.com", "a.com"},
+			slot:   true, hold: d,
+			min: 3 * d, concurrent: 2,
+		},
+		{
+			name:   "per host across hosts",
+			limits: RateLimits{PerHost: 1},
+			hosts:  []string{"a.com", "b.com", "c.com", "d.com"},
+			slot:   true, hold: d,
+			min: d, max: 3 * d, concurrent: 4,
+		},
+		{
+			name:   "per host without slots",
+			limits: RateLimits{PerHost: 1},
+			hosts:  []string{"a.com", "a.com", "a.com", "a.com"},
+			hold:   d,
+			min:    d, max: 3 * d, concurrent: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		l := newLimiter(tt.limits)
+		if tt.crawlDelay > 0 {
+			for _, host := range tt.hosts {
+				l.setHostDelay(host, tt.crawlDelay)
+			}
+		}
+
+		var mu sync.Mutex
+		var inFlight, concurrent int
+		var wg sync.WaitGroup
+		start := time.Now()
+		for _, host := range tt.hosts {
+			wg.Add(1)
+			go func(host string) {
+				defer wg.Done()
+				release, err := l.acquire(context.Background(), host, tt.slot)
+				if err != nil {
+					t.Errorf("%s: acquire(%q) failed: %v", tt.name, host, err)
+					return
+				}
+				mu.Lock()
+				inFlight++
+				if inFlight > concurrent {
+					concurrent = inFlight
+				}
+				mu.Unlock()
+
+				time.Sleep(tt.hold)
+
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+				release()
+			}(host)
+		}
+		wg.Wait()
+		elapsed := time.Since(start)
+
+		if elapsed < tt.min || (tt.max > 0 && elapsed >= tt.max) {
+			t.Errorf("%s: %d requests took %v, want at least %v and under %v", tt.name, len(tt.hosts), elapsed, tt.min, tt.max)
+		}
+		if concurrent > tt.concurrent {
+			t.Errorf("%s: %d requests in flight at once, want at most %d", tt.name, concurrent, tt.concurrent)
+		}
+	}
+}
+
+func TestLimiterCancelled(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits RateLimits
+	}{
+		{"waiting for a slot", RateLimits{PerHost: 1}},
+		{"waiting for the host delay", RateLimits{HostDelay: time.Minute}},
+		{"waiting for the rate limit", RateLimits{RequestsPerSecond: 0.01}},
+	}
+	for _, tt := range tests {
+		l := newLimiter(tt.limits)
+		release, err := l.acquire(context.Background(), "a.com", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err = l.acquire(ctx, "a.com", true)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Errorf("%s: acquire returned %v, want %v", tt.name, err, context.DeadlineExceeded)
+		}
+		release()
+
+		// A cancelled request gives up its slot.
+		if tt.limits.PerHost > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			release, err := l.acquire(ctx, "a.com", true)
+			cancel()
+			if err != nil {
+				t.Errorf("%s: acquire after cancellation failed: %v", tt.name, err)
+			} else {
+				release()
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package searcher
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Mode determines how search terms are matched against page text.
+type Mode int
+
+const (
+	// ModeSubstring matches terms anywhere in the text. This is the default.
+	ModeSubstring Mode = iota
+
+	// ModeRegex treats each term as a regular expression.
+	ModeRegex
+
+	// ModeWholeWord matches terms only when they are not part of a larger word.
+	ModeWholeWord
+)
+
+// String returns the flag value for the mode.
+func (m Mode) String() string {
+	switch m {
+	case ModeSubstring:
+		return "substring"
+	case ModeRegex:
+		return "regex"
+	case ModeWholeWord:
+		return "whole-word"
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// ParseMode takes the name of a mode ("substring", "regex"
+// or "whole-word") and returns the corresponding Mode.
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "substring", "":
+		return ModeSubstring, nil
+	case "regex":
+		return ModeRegex, nil
+	case "whole-word":
+		return ModeWholeWord, nil
+	}
+	return 0, fmt.Errorf("unknown match mode %q", s)
+}
+
+// matcher matches a single search term against page text.
+// All comparisons are case-insensitive.
+type matcher struct {
+	term string
+	mode Mode
+
+	// folded is the case folded and normalized term, used by the
+	// substring and whole-word modes.
+	folded string
+
+	// re is the compiled term, used by the regex mode.
+	re *regexp.Regexp
+}
+
+// newMatcher takes a search term, a mode and the folder used for the
+// page text, and returns a matcher, or an error if the term is not
+// valid for the mode.
+func newMatcher(term string, mode Mode, f folder) (*matcher, error) {
+	m := &matcher{
+		term: term,
+		mode: mode,
+	}
+	m.folded, _ = f.fold(term)
+
+	if mode == ModeRegex {
+		re, err := regexp.Compile("(?i)" + term)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %v", term, err)
+		}
+		m.re = re
+	}
+
+	return m, nil
+}
+
+// find returns the start and end byte offsets in the page text of
+// each non-overlapping occurrence of the term at or after the byte
+// offset from.
+func (m *matcher) find(p *page, from int) [][2]int {
+	var locs [][2]int
+
+	// Regular expressions are matched against the original text,
+	// relying on the (?i) flag for case-insensitivity.
+	if m.mode == ModeRegex {
+		for _, loc := range m.re.FindAllStringIndex(p.text[from:], -1) {
+			locs = append(locs, [2]int{from + loc[0], from + loc[1]})
+		}
+		return locs
+	}
+
+	// Otherwise search the folded text and map the
+	// offsets back to the original text.
+	if m.folded == "" {
+		return nil
+	}
+	s := p.folded
+	for offset := p.position(from); offset < len(s); {
+		i := strings.Index(s[offset:], m.folded)
+		if i < 0 {
+			break
+		}
+		start := offset + i
+		end := start + len(m.folded)
+
+		// In whole-word mode, skip occurrences inside a larger word by
+		// stepping past the first rune of the occurrence and looking again.
+		if m.mode == ModeWholeWord {
+			before, _ := utf8.DecodeLastRuneInString(s[:start])
+			after, _ := utf8.DecodeRuneInString(s[end:])
+			if isWordRune(before) || isWordRune(after) {
+				_, size := utf8.DecodeRuneInString(s[start:])
+				offset = start + size
+				continue
+			}
+		}
+
+		locs = append(locs, [2]int{p.origin(start), p.origin(end)})
+		offset = end
+	}
+
+	return locs
+}
+
+// isWordRune reports whether r is part of a word. RuneError is
+// returned at either end of the text and is not part of a word.
+func isWordRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_')
+}
+
+// page holds the text extracted from a page along with a case
+// folded and normalized copy used for comparisons.
+type page struct {
+	text   string
+	folded string
+
+	// offsets maps each byte offset in folded to the corresponding
+	// byte offset in text. It is nil when folding did not change the
+	// text's offsets, in which case they are identical.
+	offsets []int
+}
+
+// newPage takes the text extracted from a page and the folder for
+// the query and returns a page. Folding is done once here rather
+// than once per term.
+func newPage(text string, f folder) *page {
+	folded, offsets := f.fold(text)
+	return &page{text: text, folded: folded, offsets: offsets}
+}
+
+// origin takes a byte offset in the folded text and
+// returns the corresponding byte offset in the original text.
+func (p *page) origin(i int) int {
+	if p.offsets == nil {
+		return i
+	}
+	return p.offsets[i]
+}
+
+// position takes a byte offset in the original text and returns the
+// first byte offset in the folded text that maps to it or later.
+func (p *page) position(i int) int {
+	if p.offsets == nil {
+		return i
+	}
+	return sort.SearchInts(p.offsets, i)
+}
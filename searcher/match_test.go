@@ -0,0 +1,111 @@
+package searcher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Mode
+		err  bool
+	}{
+		{s: "", want: ModeSubstring},
+		{s: "substring", want: ModeSubstring},
+		{s: "regex", want: ModeRegex},
+		{s: "whole-word", want: ModeWholeWord},
+		{s: "word", err: true},
+		{s: "Regex", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMode(tt.s)
+		if (err != nil) != tt.err || (!tt.err && got != tt.want) {
+			t.Errorf("ParseMode(%q) = %v, %v; want %v (error %v)", tt.s, got, err, tt.want, tt.err)
+		}
+		if !tt.err && tt.s != "" && got.String() != tt.s {
+			t.Errorf("%v.String() = %q, want %q", got, got.String(), tt.s)
+		}
+	}
+}
+
+func TestMatcherFind(t *testing.T) {
+	tests := []struct {
+		term    string
+		mode    Mode
+		accents bool
+		text    string
+		want    []string
+	}{
+		// Substring matches are case-insensitive and may be part of
+		// a larger word.
+		{term: "cat", text: "Cat, concatenate, CAT.", want: []string{"Cat", "cat", "CAT"}},
+		{term: "aa", text: "aaaaa", want: []string{"aa", "aa"}},
+		{term: "cookie policy", text: "Our Cookie Policy.", want: []string{"Cookie Policy"}},
+		{term: "ﬁle", text: "a file", want: []string{"file"}},
+		{term: "file", text: "ＦＩＬＥ ﬁle", want: []string{"ＦＩＬＥ", "ﬁle"}},
+		{term: "straße", text: "STRASSE Straße", want: []string{"STRASSE", "Straße"}},
+		{term: "café", text: "cafe café", want: []string{"café"}},
+		{term: "café", accents: true, text: "cafe café CAFÉ", want: []string{"cafe", "café", "CAFÉ"}},
+		{term: "", text: "anything", want: nil},
+
+		// Whole words are delimited by anything but letters, digits
+		// and underscores.
+		{term: "cat", mode: ModeWholeWord, text: "cat concat cats (cat) cat_ 1cat Cat", want: []string{"cat", "cat", "Cat"}},
+		{term: "ab", mode: ModeWholeWord, text: "aab ab", want: []string{"ab"}},
+		{term: "naïve", mode: ModeWholeWord, accents: true, text: "naive naïveté NAÏVE", want: []string{"naive", "NAÏVE"}},
+		{term: "e-mail", mode: ModeWholeWord, text: "e-mail e-mails", want: []string{"e-mail"}},
+		{term: "東京", mode: ModeWholeWord, text: "東京 東京都", want: []string{"東京"}},
+
+		// Regular expressions match the original text, ignoring case.
+		{term: `colou?r`, mode: ModeRegex, text: "Color colour COLOUR", want: []string{"Color", "colour", "COLOUR"}},
+		{term: `\bcat\b`, mode: ModeRegex, text: "cat concat", want: []string{"cat"}},
+		{term: `\d{3}-\d{4}`, mode: ModeRegex, text: "call 555-1234 or 5551234", want: []string{"555-1234"}},
+		{term: `x*`, mode: ModeRegex, text: "ab", want: []string{"", "", ""}},
+	}
+
+	for _, tt := range tests {
+		f := newFolder(NormalizeNFKC, tt.accents)
+		m, err := newMatcher(tt.term, tt.mode, f)
+		if err != nil {
+			t.Fatalf("newMatcher(%q, %v) failed: %v", tt.term, tt.mode, err)
+		}
+		var got []string
+		for _, loc := range m.find(newPage(tt.text, f), 0) {
+			got = append(got, tt.text[loc[0]:loc[1]])
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v %q in %q = %q, want %q", tt.mode, tt.term, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestMatcherFindFrom(t *testing.T) {
+	text := "Ｃat cat CAT"
+	f := newFolder(NormalizeNFKC, false)
+	for _, mode := range []Mode{ModeSubstring, ModeWholeWord, ModeRegex} {
+		m, err := newMatcher("cat", mode, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := newPage(text, f)
+
+		// Searching from an offset finds only the occurrences at or
+		// after it.
+		want := [][2]int{{6, 9}, {10, 13}}
+		if got := m.find(p, 5); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: find from 5 = %v, want %v", mode, got, want)
+		}
+	}
+}
+
+func TestNewMatcherInvalidRegex(t *testing.T) {
+	for _, term := range []string{"(", "a[", "*a"} {
+		if _, err := newMatcher(term, ModeRegex, newFolder(NormalizeNFKC, false)); err == nil {
+			t.Errorf("newMatcher(%q, regex) succeeded, want an error", term)
+		}
+		if _, err := newMatcher(term, ModeSubstring, newFolder(NormalizeNFKC, false)); err != nil {
+			t.Errorf("newMatcher(%q, substring) failed: %v", term, err)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package searcher
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/timehop/golog/log"
+)
+
+// schemePattern matches a scheme at the start of a URL, as opposed to
+// a "://" elsewhere in it, such as in a query string.
+var schemePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*://`)
+
+// hasScheme reports whether a URL starts with a scheme.
+func hasScheme(site string) bool {
+	return schemePattern.MatchString(site)
+}
+
+// NormalizeURL takes a site as it appeared in the input and returns
+// its normalized URL, and whether the input specified a scheme.
+//
+// Sites without a scheme default to HTTPS. The scheme and host are
+// lowercased, internationalized host names are converted to punycode,
+// default ports and fragments are removed, and an empty path becomes
+// "/", so that "Example.com" and "https://example.com:443/" normalize
+// to the same URL.
+func NormalizeURL(site string) (*url.URL, bool, error) {
+	site = strings.TrimSpace(site)
+	if site == "" {
+		return nil, false, fmt.Errorf("empty URL")
+	}
+
+	// Default to HTTPS when no scheme is given.
+	explicit := hasScheme(site)
+	raw := site
+	if !explicit {
+		raw = "https://" + site
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, false, err
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, false, fmt.Errorf("unsupported scheme %q in %q", u.Scheme, site)
+	}
+	if u.Hostname() == "" {
+		return nil, false, fmt.Errorf("no host in %q", site)
+	}
+
+	// Normalize the host, dropping the port if it is the default for the scheme.
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid host in %q: %v", site, err)
+	}
+	port := u.Port()
+	if (u.Scheme == "https" && port == "443") || (u.Scheme == "http" && port == "80") {
+		port = ""
+	}
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+
+	// An empty path is the root, and fragments are never sent to the server.
+	if u.Path == "" {
+		u.Path = "/"
+		u.RawPath = ""
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	return u, explicit, nil
+}
+
+// normalizeHost lowercases a host name, removes any trailing dot,
+// and converts internationalized labels to punycode.
+func normalizeHost(host string) (string, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if label == "" {
+			return "", fmt.Errorf("empty label")
+		}
+		if !isASCII(label) {
+			labels[i] = "xn--" + punycode(label)
+		}
+	}
+
+	return strings.Join(labels, "."), nil
+}
+
+// fetchURLs takes a normalized URL and whether its scheme was given
+// explicitly, and returns the URLs to try in order. Sites without an
+// explicit scheme are tried over HTTPS first and then HTTP. If the
+// host is a domain without a 'www' prefix, the same URLs with the
+// prefix are tried last.
+func fetchURLs(u *url.URL, explicit bool) []string {
+	schemes := []string{u.Scheme}
+	if !explicit {
+		schemes = []string{"https", "http"}
+	}
+
+	hosts := []string{u.Host}
+	if !strings.HasPrefix(u.Host, "www.") && net.ParseIP(u.Hostname()) == nil && strings.Contains(u.Hostname(), ".") {
+		hosts = append(hosts, "www."+u.Host)
+	}
+
+	var urls []string
+	for _, host := range hosts {
+		for _, scheme := range schemes {
+			v := *u
+			v.Scheme = scheme
+			v.Host = host
+			urls = append(urls, v.String())
+		}
+	}
+
+	return urls
+}
+
+// dedupe removes records whose URLs normalize to the same URL as an
+// earlier record. Records that do not normalize are kept so that the
+// error is reported in their result.
+func dedupe(records []Record) []Record {
+	seen := map[string]int{}
+	var unique []Record
+	for _, record := range records {
+		u, _, err := NormalizeURL(record.URL)
+		if err == nil {
+			// Sites without a scheme match either scheme, so compare
+			// URLs without their scheme.
+			key := strings.TrimPrefix(u.String(), u.Scheme+":")
+			if line, ok := seen[key]; ok {
+				log.Warn("go-search", fmt.Sprintf("Skipping line %d: %q duplicates line %d", record.Line, record.URL, line))
+				continue
+			}
+			seen[key] = record.Line
+		}
+		unique = append(unique, record)
+	}
+	return unique
+}
+
+// isASCII reports whether s contains only ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// Punycode parameters from RFC 3492.
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// punycode encodes a single host label as described in RFC 3492,
+// without the "xn--" prefix.
+func punycode(label string) string {
+	runes := []rune(label)
+
+	// Copy the basic (ASCII) code points to the output first.
+	var out []byte
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+	b := len(out)
+	h := b
+	if b > 0 {
+		out = append(out, '-')
+	}
+
+	// Encode the remaining code points as deltas, in order of code point.
+	n, delta, bias := rune(punyInitialN), 0, punyInitialBias
+	for h < len(runes) {
+		m := rune(utf8.MaxRune)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		delta += int(m-n) * (h + 1)
+		n = m
+
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := k - bias
+				if t < punyTMin {
+					t = punyTMin
+				} else if t > punyTMax {
+					t = punyTMax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punyDigit(q))
+			bias = punyAdapt(delta, h+1, h == b)
+			delta = 0
+			h++
+		}
+		delta++
+		n++
+	}
+
+	return string(out)
+}
+
+// punyAdapt is the bias adaptation function from RFC 3492.
+func punyAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+// punyDigit returns the character for a punycode digit.
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
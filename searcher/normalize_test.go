@@ -0,0 +1,90 @@
+package searcher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPunycode(t *testing.T) {
+	tests := []struct {
+		label, want string
+	}{
+		{"bücher", "bcher-kva"},
+		{"münchen", "mnchen-3ya"},
+		{"españa", "espaa-rta"},
+		{"例え", "r8jz45g"},
+		{"пример", "e1afmkfd"},
+		{"ü", "tda"},
+		// From the sample strings in RFC 3492, section 7.1.
+		{"他们为什么不说中文", "ihqwcrb4cv8a8dqg056pqjye"},
+		{"3年B組金八先生", "3B-ww4c5e180e575a65lsy2b"},
+	}
+	for _, tt := range tests {
+		if got := punycode(tt.label); got != tt.want {
+			t.Errorf("punycode(%q) = %q, want %q", tt.label, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		site     string
+		want     string
+		explicit bool
+		err      bool
+	}{
+		{site: "example.com", want: "https://example.com/"},
+		{site: "  Example.COM  ", want: "https://example.com/"},
+		{site: "https://example.com:443/", want: "https://example.com/", explicit: true},
+		{site: "HTTP://Example.com:80/a?b=c#frag", want: "http://example.com/a?b=c", explicit: true},
+		{site: "http://example.com:8080", want: "http://example.com:8080/", explicit: true},
+		{site: "example.com.", want: "https://example.com/"},
+		{site: "localhost:8080", want: "https://localhost:8080/"},
+		{site: "bücher.example", want: "https://xn--bcher-kva.example/"},
+		{site: "http://[::1]:8080/", want: "http://[::1]:8080/", explicit: true},
+		{site: "example.com/redirect?to=http://other.com", want: "https://example.com/redirect?to=http://other.com"},
+		{site: "example.com/a://b", want: "https://example.com/a://b"},
+		{site: "", err: true},
+		{site: "ftp://example.com", err: true},
+		{site: "https://", err: true},
+		{site: "example..com", err: true},
+	}
+	for _, tt := range tests {
+		u, explicit, err := NormalizeURL(tt.site)
+		if tt.err {
+			if err == nil {
+				t.Errorf("NormalizeURL(%q) = %s, want an error", tt.site, u)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NormalizeURL(%q) failed: %v", tt.site, err)
+			continue
+		}
+		if u.String() != tt.want || explicit != tt.explicit {
+			t.Errorf("NormalizeURL(%q) = %s, %v, want %s, %v", tt.site, u, explicit, tt.want, tt.explicit)
+		}
+	}
+}
+
+func TestFetchURLs(t *testing.T) {
+	tests := []struct {
+		site string
+		want []string
+	}{
+		{"example.com", []string{"https://example.com/", "http://example.com/", "https://www.example.com/", "http://www.example.com/"}},
+		{"http://example.com", []string{"http://example.com/", "http://www.example.com/"}},
+		{"www.example.com", []string{"https://www.example.com/", "http://www.example.com/"}},
+		{"127.0.0.1:8080", []string{"https://127.0.0.1:8080/", "http://127.0.0.1:8080/"}},
+		{"localhost", []string{"https://localhost/", "http://localhost/"}},
+	}
+	for _, tt := range tests {
+		u, explicit, err := NormalizeURL(tt.site)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fetchURLs(u, explicit); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("fetchURLs(%q) = %q, want %q", tt.site, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package searcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Format is an output format for results.
+type Format int
+
+const (
+	// FormatText writes results in tab-separated columns. This is the default.
+	FormatText Format = iota
+
+	// FormatJSON writes results as a single JSON array.
+	FormatJSON
+
+	// FormatNDJSON writes results as newline-delimited JSON,
+	// one object per line.
+	FormatNDJSON
+)
+
+// String returns the flag value for the format.
+func (f Format) String() string {
+	switch f {
+	case FormatText:
+		return "text"
+	case FormatJSON:
+		return "json"
+	case FormatNDJSON:
+		return "ndjson"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// Ext returns the conventional file extension for the format.
+func (f Format) Ext() string {
+	switch f {
+	case FormatJSON:
+		return ".json"
+	case FormatNDJSON:
+		return ".ndjson"
+	}
+	return ".txt"
+}
+
+// ParseFormat takes the name of a format ("text", "json"
+// or "ndjson") and returns the corresponding Format.
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "text", "":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	case "ndjson":
+		return FormatNDJSON, nil
+	}
+	return 0, fmt.Errorf("unknown output format %q", s)
+}
+
+// WriteResults takes a slice of results and writes them to w
+// in tab-separated columns. It returns the number of bytes written.
+func WriteResults(w io.Writer, results []Result) (int, error) {
+
+	// Create a new tabwriter.Writer and specify the output and
+	// formatting (tab-separated columns with a tab stop of 4).
+	// FYI: This will look nice in a text editor, but not notepad.
+	tw := new(tabwriter.Writer)
+	tw.Init(
+		w,    // output
+		0,    // minwidth
+		4,    // tabwidth
+		1,    // padding
+		'\t', // padchar
+		0,    // flags
+	)
+
+	// Any columns carried through from the input are written
+	// after the site. Every result carries the same columns.
+	var columns string
+	if len(results) > 0 {
+		for _, c := range results[0].Columns {
+			columns += c.Name + "\t"
+		}
+	}
+
+	// If any site was crawled, the pages matched are written
+	// after the count.
+	crawled := false
+	for _, result := range results {
+		crawled = crawled || result.Pages > 0
+	}
+	pagesHeader := ""
+	if crawled {
+		pagesHeader = "Pages\tMatched Pages\t"
+	}
+
+	// Range through the results and construct the fileContents.
+	// The match columns are left blank for pages that were not searched.
+	fileContents := "Site\t" + columns + "Outcome\tStatus\tFound\tMatched\tCount\t" + pagesHeader + "Snippets\tError\t\n"
+	for _, result := range results {
+		columns = ""
+		for _, c := range result.Columns {
+			columns += c.Value + "\t"
+		}
+
+		status := ""
+		if result.StatusCode != 0 {
+			status = strconv.Itoa(result.StatusCode)
+		}
+
+		errString := ""
+		if result.Err != nil {
+			errString = result.Err.Error()
+		}
+
+		pages := ""
+		if crawled {
+			pages = "\t\t"
+			if result.Searched {
+				pages = fmt.Sprintf("%d\t%s\t", result.Pages, strings.Join(result.MatchedPages, ", "))
+			}
+		}
+
+		if result.Searched {
+			fileContents += fmt.Sprintf("%s\t%s%s\t%s\t%t\t%s\t%d\t%s%s\t%s\n", result.Site, columns, result.Outcome, status, result.Found, strings.Join(result.Matched, ", "), result.Count, pages, formatSnippets(result.Snippets), errString)
+		} else {
+			fileContents += fmt.Sprintf("%s\t%s%s\t%s\t%s\t%s\t%s\t%s%s\t%s\n", result.Site, columns, result.Outcome, status, "", "", "", pages, "", errString)
+		}
+	}
+
+	// Write the fileContents to the writer.
+	n, err := io.WriteString(tw, fileContents)
+	if err != nil {
+		return n, err
+	}
+
+	// Flush the writer.
+	err = tw.Flush()
+	if err != nil {
+		return n, err
+	}
+
+	return n, nil
+}
+
+// formatSnippets takes a slice of snippets and returns them on a
+// single line, each prefixed with its line number and byte offset,
+// its region if the search was scoped to regions, and its page's
+// URL if the site was crawled.
+func formatSnippets(snippets []Snippet) string {
+	var parts []string
+	for _, snippet := range snippets {
+		part := fmt.Sprintf("L%d@%d %q", snippet.Line, snippet.Offset, snippet.Text)
+		if snippet.Region != "" {
+			part = string(snippet.Region) + ":" + part
+		}
+		if snippet.URL != "" {
+			part = snippet.URL + " " + part
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " | ")
+}
+
+// WriteJSON takes a slice of results and writes them to w as a
+// single indented JSON array.
+func WriteJSON(w io.Writer, results []Result) error {
+	if results == nil {
+		results = []Result{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+// WriteNDJSON takes a single result and writes it to w as one line
+// of JSON. It is intended to be called as each result arrives.
+func WriteNDJSON(w io.Writer, result Result) error {
+	return json.NewEncoder(w).Encode(result)
+}
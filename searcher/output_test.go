@@ -0,0 +1,169 @@
+package searcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Format
+		ext  string
+		err  bool
+	}{
+		{s: "", want: FormatText, ext: ".txt"},
+		{s: "text", want: FormatText, ext: ".txt"},
+		{s: "json", want: FormatJSON, ext: ".json"},
+		{s: "ndjson", want: FormatNDJSON, ext: ".ndjson"},
+		{s: "jsonl", err: true},
+		{s: "JSON", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFormat(tt.s)
+		if (err != nil) != tt.err || (!tt.err && got != tt.want) {
+			t.Errorf("ParseFormat(%q) = %v, %v; want %v (error %v)", tt.s, got, err, tt.want, tt.err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		if got.Ext() != tt.ext {
+			t.Errorf("%v.Ext() = %q, want %q", got, got.Ext(), tt.ext)
+		}
+		if tt.s != "" && got.String() != tt.s {
+			t.Errorf("%v.String() = %q, want %q", got, got.String(), tt.s)
+		}
+	}
+}
+
+func TestResultMarshalJSON(t *testing.T) {
+	tests := []struct {
+		result Result
+		want   string
+	}{
+		{
+			// Empty lists are encoded as empty arrays, and unset
+			// optional fields are left out.
+			result: Result{Site: "example.com", Index: 2, Outcome: OutcomeOK, Searched: true},
+			want:   `{"site":"example.com","index":2,"outcome":"ok","searched":true,"found":false,"matched":[],"count":0,"snippets":[],"duration_ms":0}`,
+		},
+		{
+			result: Result{
+				Site:         "example.com",
+				Columns:      []Column{{Name: "id", Value: "7"}, {Name: "name", Value: "Example"}},
+				Outcome:      OutcomeOK,
+				Searched:     true,
+				Found:        true,
+				Matched:      []string{"cookie", "privacy"},
+				Count:        3,
+				Regions:      []Region{RegionBody, RegionLinks},
+				Snippets:     []Snippet{{Term: "cookie", Text: "our cookie policy", Offset: 12, Line: 2, Region: RegionBody, URL: "https://example.com/about"}},
+				Pages:        4,
+				MatchedPages: []string{"https://example.com/about"},
+				StatusCode:   200,
+				Normalized:   "https://example.com",
+				URL:          "https://www.example.com/",
+				Redirects:    []Redirect{{URL: "https://example.com", StatusCode: 301}},
+				Truncated:    true,
+				StoppedEarly: true,
+				Charset:      "windows-1252",
+				Cache:        "hit",
+				Duration:     1500 * time.Millisecond,
+				Attempts:     2,
+			},
+			want: `{"site":"example.com","index":0,"columns":{"id":"7","name":"Example"},"outcome":"ok","searched":true,"found":true,` +
+				`"matched":["cookie","privacy"],"count":3,"regions":["body","links"],` +
+				`"snippets":[{"term":"cookie","text":"our cookie policy","offset":12,"line":2,"region":"body","url":"https://example.com/about"}],` +
+				`"pages_searched":4,"matched_pages":["https://example.com/about"],"status_code":200,"normalized_url":"https://example.com",` +
+				`"final_url":"https://www.example.com/","redirects":[{"url":"https://example.com","status_code":301}],` +
+				`"truncated":true,"stopped_early":true,"charset":"windows-1252","cache":"hit","duration_ms":1500,"attempts":2}`,
+		},
+		{
+			// Errors are encoded as their message and kind.
+			result: Result{Site: "example.com", Outcome: OutcomeHTTPError, StatusCode: 404, Err: &Error{Kind: ErrorHTTPStatus, Err: errors.New("404 Not Found")}},
+			want:   `{"site":"example.com","index":0,"outcome":"http_error","searched":false,"found":false,"matched":[],"count":0,"snippets":[],"status_code":404,"duration_ms":0,"error":"http_status: 404 Not Found","error_kind":"http_status"}`,
+		},
+		{
+			result: Result{Site: "example.com", Outcome: OutcomeCancelled, Err: ErrCancelled},
+			want:   `{"site":"example.com","index":0,"outcome":"cancelled","searched":false,"found":false,"matched":[],"count":0,"snippets":[],"duration_ms":0,"error":"` + ErrCancelled.Error() + `","error_kind":"cancelled"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := json.Marshal(tt.result)
+		if err != nil {
+			t.Errorf("json.Marshal(%+v) failed: %v", tt.result, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("json.Marshal(%+v) =\n%s\nwant\n%s", tt.result, got, tt.want)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	tests := []struct {
+		results []Result
+		want    []string
+	}{
+		{nil, nil},
+		{[]Result{}, nil},
+		{[]Result{{Site: "a.com"}, {Site: "b.com", Index: 1}}, []string{"a.com", "b.com"}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteJSON(&buf, tt.results); err != nil {
+			t.Fatal(err)
+		}
+
+		// The results are written as a single array, never null.
+		var got []map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil || got == nil {
+			t.Errorf("WriteJSON(%d results) wrote %q, want a JSON array", len(tt.results), buf.String())
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("WriteJSON(%d results) wrote %d results", len(tt.results), len(got))
+			continue
+		}
+		for i, site := range tt.want {
+			if got[i]["site"] != site {
+				t.Errorf("WriteJSON: result %d has site %v, want %q", i, got[i]["site"], site)
+			}
+		}
+	}
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	results := []Result{
+		{Site: "a.com", Outcome: OutcomeOK, Searched: true, Found: true, Matched: []string{"x"}, Count: 1},
+		{Site: "b.com", Index: 1, Outcome: OutcomeError, Err: &Error{Kind: ErrorDNS, Err: errors.New("no such host")}},
+	}
+	var buf bytes.Buffer
+	for _, r := range results {
+		if err := WriteNDJSON(&buf, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each result is a single line holding the same object as its
+	// JSON encoding.
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(results) {
+		t.Fatalf("WriteNDJSON wrote %d lines for %d results: %q", len(lines), len(results), buf.String())
+	}
+	for i, r := range results {
+		want, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines[i] != string(want) {
+			t.Errorf("line %d = %s, want %s", i, lines[i], want)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package searcher
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A query is a boolean expression over search terms. The syntax is:
+//
+//	expr    = orExpr
+//	orExpr  = andExpr { "OR" andExpr }
+//	andExpr = notExpr { ["AND"] notExpr }
+//	notExpr = "NOT" notExpr | primary
+//	primary = "(" expr ")" | phrase | words
+//
+// Operators must be written in upper case. A phrase is a quoted
+// string in which \" and \\ may be used as escapes. Consecutive
+// unquoted words form a single term, so `cookie policy` searches for
+// the text "cookie policy" rather than for the two words separately.
+type query struct {
+	root node
+
+	// leaves lists the distinct terms in the query in the order
+	// they first appear.
+	leaves []*matcher
+
+	// folder folds the page text before it is matched.
+	folder folder
+}
+
+// node is a node in the query AST. eval reports whether the node
+// matches given which leaf terms were found on the page.
+type node interface {
+	eval(found map[*matcher]bool) bool
+	String() string
+}
+
+type (
+	andNode  struct{ left, right node }
+	orNode   struct{ left, right node }
+	notNode  struct{ operand node }
+	termNode struct{ m *matcher }
+)
+
+func (n andNode) eval(found map[*matcher]bool) bool {
+	return n.left.eval(found) && n.right.eval(found)
+}
+
+func (n orNode) eval(found map[*matcher]bool) bool {
+	return n.left.eval(found) || n.right.eval(found)
+}
+
+func (n notNode) eval(found map[*matcher]bool) bool {
+	return !n.operand.eval(found)
+}
+
+func (n termNode) eval(found map[*matcher]bool) bool {
+	return found[n.m]
+}
+
+func (n andNode) String() string  { return "(" + n.left.String() + " AND " + n.right.String() + ")" }
+func (n orNode) String() string   { return "(" + n.left.String() + " OR " + n.right.String() + ")" }
+func (n notNode) String() string  { return "NOT " + n.operand.String() }
+func (n termNode) String() string { return fmt.Sprintf("%q", n.m.term) }
+
+// parseQueries takes a slice of query expressions, a mode and the
+// folder for the page text, and returns a single query matching if
+// any of the expressions match. Blank expressions are ignored. In
+// ModeRegex each expression is a single regular expression, as the
+// query syntax would change the meaning of its parentheses.
+func parseQueries(exprs []string, mode Mode, f folder) (*query, error) {
+	q := &query{folder: f}
+	leaves := map[string]*matcher{}
+
+	for _, expr := range exprs {
+		if strings.TrimSpace(expr) == "" {
+			continue
+		}
+
+		p := &parser{src: expr, mode: mode, folder: f, leaves: leaves, q: q}
+		var root node
+		var err error
+		if mode == ModeRegex {
+			root, err = p.term(expr)
+		} else {
+			root, err = p.parse()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid search %q: %v", expr, err)
+		}
+
+		if q.root == nil {
+			q.root = root
+		} else {
+			q.root = orNode{q.root, root}
+		}
+	}
+
+	if q.root == nil {
+		return nil, ErrNoTerm
+	}
+
+	return q, nil
+}
+
+// tokenKind identifies the type of a query token.
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenLParen
+	tokenRParen
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenPhrase
+	tokenWord
+)
+
+// token is a lexical token in a query. start and end are
+// byte offsets of the token in the source expression.
+type token struct {
+	kind       tokenKind
+	text       string
+	start, end int
+}
+
+// parser is a recursive descent parser for query expressions.
+type parser struct {
+	src  string
+	pos  int
+	mode Mode
+	tok  token
+
+	// folder folds the terms in the same way as the page text.
+	folder folder
+
+	// leaves and q are shared between the expressions in a
+	// query so that repeated terms share a single matcher.
+	leaves map[string]*matcher
+	q      *query
+}
+
+// parse parses the whole source expression.
+func (p *parser) parse() (node, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", p.tok.text, p.tok.start)
+	}
+	return n, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokenOr {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.tok.kind {
+		case tokenAnd:
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		case tokenNot, tokenLParen, tokenPhrase, tokenWord:
+			// Adjacent operands are implicitly joined with AND.
+		default:
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.tok.kind != tokenNot {
+		return p.parsePrimary()
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	operand, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return notNode{operand}, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	switch p.tok.kind {
+	case tokenLParen:
+		open := p.tok.start
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokenRParen {
+			return nil, fmt.Errorf("unclosed parenthesis at offset %d", open)
+		}
+		return n, p.next()
+
+	case tokenPhrase:
+		term := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		return p.term(term)
+
+	case tokenWord:
+		// Consecutive words form a single term, taken verbatim
+		// from the source so that inner spacing is preserved.
+		start, end := p.tok.start, p.tok.end
+		for {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if p.tok.kind != tokenWord {
+				break
+			}
+			end = p.tok.end
+		}
+		return p.term(p.src[start:end])
+
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of query")
+	}
+
+	return nil, fmt.Errorf("unexpected %q at offset %d", p.tok.text, p.tok.start)
+}
+
+// term returns a leaf node for the term, reusing the
+// matcher if the term has already appeared in the query.
+func (p *parser) term(term string) (node, error) {
+	if term == "" {
+		return nil, fmt.Errorf("empty phrase")
+	}
+	if m, ok := p.leaves[term]; ok {
+		return termNode{m}, nil
+	}
+	m, err := newMatcher(term, p.mode, p.folder)
+	if err != nil {
+		return nil, err
+	}
+	p.leaves[term] = m
+	p.q.leaves = append(p.q.leaves, m)
+	return termNode{m}, nil
+}
+
+// next advances the parser to the next token in the source.
+func (p *parser) next() error {
+
+	// Skip whitespace.
+	for p.pos < len(p.src) {
+		r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		p.pos += size
+	}
+
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokenEOF, start: start, end: start}
+		return nil
+	}
+
+	switch c := p.src[p.pos]; c {
+	case '(':
+		p.pos++
+		p.tok = token{tokenLParen, "(", start, p.pos}
+		return nil
+	case ')':
+		p.pos++
+		p.tok = token{tokenRParen, ")", start, p.pos}
+		return nil
+	case '"':
+		return p.phrase()
+	}
+
+	// Read a word up to the next space, parenthesis or quote.
+	for p.pos < len(p.src) {
+		r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		if unicode.IsSpace(r) || r == '(' || r == ')' || r == '"' {
+			break
+		}
+		p.pos += size
+	}
+
+	word := p.src[start:p.pos]
+	kind := tokenWord
+	switch word {
+	case "AND":
+		kind = tokenAnd
+	case "OR":
+		kind = tokenOr
+	case "NOT":
+		kind = tokenNot
+	}
+	p.tok = token{kind, word, start, p.pos}
+	return nil
+}
+
+// phrase reads a quoted phrase, unescaping \" and \\.
+func (p *parser) phrase() error {
+	start := p.pos
+	p.pos++ // opening quote
+
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			p.tok = token{tokenPhrase, b.String(), start, p.pos}
+			return nil
+		case c == '\\' && p.pos+1 < len(p.src) && (p.src[p.pos+1] == '"' || p.src[p.pos+1] == '\\'):
+			b.WriteByte(p.src[p.pos+1])
+			p.pos += 2
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+
+	return fmt.Errorf("unterminated quote at offset %d", start)
+}
@@ -0,0 +1,63 @@
+package searcher
+
+import "testing"
+
+func TestParseQueries(t *testing.T) {
+	tests := []struct {
+		exprs []string
+		mode  Mode
+		want  string
+		err   bool
+	}{
+		{exprs: []string{"google"}, want: `"google"`},
+		{exprs: []string{"cookie policy"}, want: `"cookie policy"`},
+		{exprs: []string{`"google analytics" AND NOT gtag`}, want: `("google analytics" AND NOT "gtag")`},
+		{exprs: []string{`"a" "b"`}, want: `("a" AND "b")`},
+		{exprs: []string{"a OR b c"}, want: `("a" OR "b c")`},
+		{exprs: []string{"a AND (b OR c)"}, want: `("a" AND ("b" OR "c"))`},
+		{exprs: []string{"NOT NOT a"}, want: `NOT NOT "a"`},
+		{exprs: []string{`"say \"hi\""`}, want: `"say \"hi\""`},
+		{exprs: []string{"a", "", "b"}, want: `("a" OR "b")`},
+		{exprs: []string{"a and b"}, want: `"a and b"`},
+		{exprs: []string{"(a"}, err: true},
+		{exprs: []string{"a)"}, err: true},
+		{exprs: []string{`"a`}, err: true},
+		{exprs: []string{`""`}, err: true},
+		{exprs: []string{"a AND"}, err: true},
+		{exprs: []string{" "}, err: true},
+
+		// Regular expressions are taken whole, without operators.
+		{exprs: []string{"colou?r (red|blue)"}, mode: ModeRegex, want: `"colou?r (red|blue)"`},
+		{exprs: []string{"foo(bar)?"}, mode: ModeRegex, want: `"foo(bar)?"`},
+		{exprs: []string{"a AND b"}, mode: ModeRegex, want: `"a AND b"`},
+		{exprs: []string{"a", "b|c"}, mode: ModeRegex, want: `("a" OR "b|c")`},
+		{exprs: []string{"foo("}, mode: ModeRegex, err: true},
+	}
+
+	for _, tt := range tests {
+		q, err := parseQueries(tt.exprs, tt.mode, newFolder(NormalizeNFKC, false))
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseQueries(%q, %v) = %s, want an error", tt.exprs, tt.mode, q.root)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseQueries(%q, %v) failed: %v", tt.exprs, tt.mode, err)
+			continue
+		}
+		if got := q.root.String(); got != tt.want {
+			t.Errorf("parseQueries(%q, %v) = %s, want %s", tt.exprs, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestParseQueriesSharesTerms(t *testing.T) {
+	q, err := parseQueries([]string{"a OR b", "b AND NOT a"}, ModeSubstring, newFolder(NormalizeNFKC, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.leaves) != 2 || q.leaves[0].term != "a" || q.leaves[1].term != "b" {
+		t.Errorf("leaves = %v, want [a b]", q.leaves)
+	}
+}
@@ -0,0 +1,411 @@
+package searcher
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Region is a part of a page that searches can be scoped to.
+type Region string
+
+const (
+	// RegionTitle is the text of the <title> element.
+	RegionTitle Region = "title"
+
+	// RegionMeta is the content of the description and keywords
+	// <meta> elements.
+	RegionMeta Region = "meta"
+
+	// RegionHeadings is the text of the <h1> to <h6> elements.
+	RegionHeadings Region = "headings"
+
+	// RegionLinks is the text and target of each link.
+	RegionLinks Region = "links"
+
+	// RegionAlt is the alt text of each image.
+	RegionAlt Region = "alt"
+
+	// RegionBody is the text of the page body, including headings
+	// and links. This is the default.
+	RegionBody Region = "body"
+
+	// RegionSelector is the text of the elements matching
+	// Options.Selector.
+	RegionSelector Region = "selector"
+)
+
+// regionOrder lists the regions from the most to the least specific.
+// Text in several selected regions is reported in the first of them.
+var regionOrder = []Region{
+	RegionSelector,
+	RegionTitle,
+	RegionMeta,
+	RegionAlt,
+	RegionLinks,
+	RegionHeadings,
+	RegionBody,
+}
+
+// ParseRegions takes a comma-separated list of region names (e.g.
+// "title,meta") and returns the corresponding regions.
+func ParseRegions(s string) ([]Region, error) {
+	var regions []Region
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		r := Region(name)
+		if r == RegionSelector || r.bit() == 0 {
+			return nil, fmt.Errorf("unknown region %q", name)
+		}
+		regions = append(regions, r)
+	}
+	return regions, nil
+}
+
+// regionSet is a set of regions, with a bit for each region.
+type regionSet uint8
+
+// bit returns the set containing only the region, or the
+// empty set if the region is unknown.
+func (r Region) bit() regionSet {
+	for i, region := range regionOrder {
+		if r == region {
+			return 1 << uint(i)
+		}
+	}
+	return 0
+}
+
+// first returns the most specific region in the set, or the
+// empty string if the set is empty.
+func (s regionSet) first() Region {
+	for i, region := range regionOrder {
+		if s&(1<<uint(i)) != 0 {
+			return region
+		}
+	}
+	return ""
+}
+
+// selector is a parsed CSS selector. It supports type, class, ID and
+// attribute selectors, combined with the descendant and child
+// combinators, and comma-separated lists of selectors.
+type selector [][]compound
+
+// compound is a compound selector such as "div.content", along with
+// the combinator linking it to the compound selector before it.
+type compound struct {
+	// child is set for the child combinator ('>'), and otherwise
+	// the compound selector matches any descendant.
+	child bool
+
+	tag     string
+	id      string
+	classes []string
+	attrs   []attrSelector
+}
+
+// attrSelector is an attribute selector such as [rel] or [rel=nofollow].
+type attrSelector struct {
+	name  string
+	value string
+	exact bool
+}
+
+// element is an open element, as matched against a selector.
+type element struct {
+	tag   string
+	attrs map[string]string
+
+	// selected reports whether the element matched the selector.
+	selected bool
+}
+
+// parseSelector takes a CSS selector and returns it parsed,
+// or an error if it uses unsupported syntax.
+func parseSelector(s string) (selector, error) {
+	var sel selector
+	var chain []compound
+	child := false
+
+	// end ends a selector in the comma-separated list.
+	end := func() error {
+		if len(chain) == 0 || child {
+			return fmt.Errorf("invalid selector %q: empty selector", s)
+		}
+		sel = append(sel, chain)
+		chain, child = nil, false
+		return nil
+	}
+
+	for _, tok := range selectorTokens(s) {
+		switch tok {
+		case ",":
+			if err := end(); err != nil {
+				return nil, err
+			}
+		case ">":
+			if len(chain) == 0 || child {
+				return nil, fmt.Errorf("invalid selector %q: misplaced '>'", s)
+			}
+			child = true
+		default:
+			c, err := parseCompound(tok)
+			if err != nil {
+				return nil, fmt.Errorf("invalid selector %q: %v", s, err)
+			}
+			c.child = child
+			chain = append(chain, c)
+			child = false
+		}
+	}
+	if err := end(); err != nil {
+		return nil, err
+	}
+	return sel, nil
+}
+
+// selectorTokens splits a selector into compound selectors, commas
+// and child combinators ('>'). Attribute selectors are kept whole, so
+// their values may contain spaces, commas and '>'.
+func selectorTokens(s string) []string {
+	var tokens []string
+	start := -1
+	flush := func(i int) {
+		if start >= 0 {
+			tokens = append(tokens, s[start:i])
+			start = -1
+		}
+	}
+
+	var quote byte
+	bracket := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case bracket:
+			if c == '"' || c == '\'' {
+				quote = c
+			} else if c == ']' {
+				bracket = false
+			}
+		case c == ',' || c == '>':
+			flush(i)
+			tokens = append(tokens, string(c))
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			flush(i)
+		default:
+			bracket = c == '['
+			if start < 0 {
+				start = i
+			}
+		}
+	}
+	flush(len(s))
+	return tokens
+}
+
+// parseCompound parses a compound selector such as "a.nav[href]".
+func parseCompound(s string) (compound, error) {
+	var c compound
+
+	// The type selector comes first, if any.
+	i := strings.IndexAny(s, "#.[")
+	if i < 0 {
+		i = len(s)
+	}
+	c.tag = strings.ToLower(s[:i])
+	if c.tag == "*" {
+		c.tag = ""
+	} else if !isName(c.tag) && c.tag != "" {
+		return c, fmt.Errorf("unsupported syntax %q", s)
+	}
+	s = s[i:]
+
+	for s != "" {
+		switch s[0] {
+		case '#', '.':
+			j := strings.IndexAny(s[1:], "#.[") + 1
+			if j == 0 {
+				j = len(s)
+			}
+			name := s[1:j]
+			if name == "" {
+				return c, fmt.Errorf("empty name after %q", s[0])
+			}
+			if !isName(name) {
+				return c, fmt.Errorf("unsupported syntax %q", s)
+			}
+			if s[0] == '#' {
+				c.id = name
+			} else {
+				c.classes = append(c.classes, name)
+			}
+			s = s[j:]
+
+		case '[':
+			j := attrEnd(s)
+			if j < 0 {
+				return c, fmt.Errorf("unclosed '['")
+			}
+			a := attrSelector{name: strings.ToLower(strings.TrimSpace(s[1:j]))}
+			if name, value, ok := strings.Cut(s[1:j], "="); ok {
+				a.name = strings.ToLower(strings.TrimSpace(name))
+				a.value = strings.Trim(strings.TrimSpace(value), `"'`)
+				a.exact = true
+			}
+			if a.name == "" {
+				return c, fmt.Errorf("empty attribute name")
+			}
+			if strings.ContainsAny(a.name, "~|^$*") {
+				return c, fmt.Errorf("unsupported attribute selector %q", s[:j+1])
+			}
+			c.attrs = append(c.attrs, a)
+			s = s[j+1:]
+
+		default:
+			return c, fmt.Errorf("unsupported syntax %q", s)
+		}
+	}
+
+	return c, nil
+}
+
+// isName reports whether s is a plain tag, id or class name, without
+// pseudo-classes, sibling combinators or escapes.
+func isName(s string) bool {
+	return s != "" && !strings.ContainsAny(s, ":()+~\\\"'*=")
+}
+
+// attrEnd returns the index of the ']' closing the attribute selector
+// at the start of s, skipping any in a quoted value, or -1 if there is
+// none.
+func attrEnd(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+// match reports whether the last element of stack, whose ancestors
+// are the elements before it, matches the selector.
+func (sel selector) match(stack []element) bool {
+	for _, chain := range sel {
+		if matchChain(chain, stack) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchChain reports whether the last element of stack matches a
+// chain of compound selectors, working from right to left.
+func matchChain(chain []compound, stack []element) bool {
+	if len(stack) == 0 || !chain[len(chain)-1].match(stack[len(stack)-1]) {
+		return false
+	}
+	if len(chain) == 1 {
+		return true
+	}
+
+	// Match the rest of the chain against the parent, or for the
+	// descendant combinator, against any ancestor.
+	last := chain[len(chain)-1]
+	for i := len(stack) - 2; i >= 0; i-- {
+		if matchChain(chain[:len(chain)-1], stack[:i+1]) {
+			return true
+		}
+		if last.child {
+			break
+		}
+	}
+	return false
+}
+
+// match reports whether an element matches the compound selector.
+func (c compound) match(e element) bool {
+	if c.tag != "" && c.tag != e.tag {
+		return false
+	}
+	if c.id != "" && e.attrs["id"] != c.id {
+		return false
+	}
+	for _, class := range c.classes {
+		found := false
+		for _, name := range strings.Fields(e.attrs["class"]) {
+			if name == class {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, a := range c.attrs {
+		value, ok := e.attrs[a.name]
+		if !ok || (a.exact && value != a.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// scope determines the part of each page that is searched.
+type scope struct {
+	target   Target
+	regions  regionSet
+	selector selector
+
+	// report is set if the search was scoped to regions, so that
+	// the region of each match is recorded.
+	report bool
+}
+
+// scope returns the scope of the Searcher's searches, or an error
+// if its selector is invalid.
+func (s *Searcher) scope() (scope, error) {
+	sc := scope{target: s.opts.Target}
+	if sc.target != TargetText && (len(s.opts.Regions) > 0 || s.opts.Selector != "") {
+		return sc, fmt.Errorf("regions and selectors can only be used with the text target")
+	}
+
+	for _, r := range s.opts.Regions {
+		if r.bit() == 0 {
+			return sc, fmt.Errorf("unknown region %q", r)
+		}
+		sc.regions |= r.bit()
+	}
+
+	if s.opts.Selector != "" {
+		sel, err := parseSelector(s.opts.Selector)
+		if err != nil {
+			return sc, err
+		}
+		sc.selector = sel
+		sc.regions |= RegionSelector.bit()
+	}
+
+	// Without any regions, search the page body as before.
+	sc.report = sc.regions != 0
+	if sc.regions == 0 {
+		sc.regions = RegionBody.bit()
+	}
+	return sc, nil
+}
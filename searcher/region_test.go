@@ -0,0 +1,124 @@
+package searcher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		s    string
+		want selector
+		err  bool
+	}{
+		{s: "main", want: selector{{{tag: "main"}}}},
+		{s: "DIV.Content", want: selector{{{tag: "div", classes: []string{"Content"}}}}},
+		{s: "*", want: selector{{{}}}},
+		{s: "#main.a.b", want: selector{{{id: "main", classes: []string{"a", "b"}}}}},
+		{s: "a[href]", want: selector{{{tag: "a", attrs: []attrSelector{{name: "href"}}}}}},
+		{s: `a[REL="nofollow"]`, want: selector{{{tag: "a", attrs: []attrSelector{{name: "rel", value: "nofollow", exact: true}}}}}},
+		{s: "[rel=nofollow]", want: selector{{{attrs: []attrSelector{{name: "rel", value: "nofollow", exact: true}}}}}},
+		{s: "article p", want: selector{{{tag: "article"}, {tag: "p"}}}},
+		{s: "ul > li", want: selector{{{tag: "ul"}, {tag: "li", child: true}}}},
+		{s: "ul>li a", want: selector{{{tag: "ul"}, {tag: "li", child: true}, {tag: "a"}}}},
+		{s: " main , .content ", want: selector{{{tag: "main"}}, {{classes: []string{"content"}}}}},
+
+		// Attribute values may contain spaces, commas, '>' and ']'.
+		{s: `[title="a, b > c"]`, want: selector{{{attrs: []attrSelector{{name: "title", value: "a, b > c", exact: true}}}}}},
+		{s: `[data-x='[1]'] p`, want: selector{{{attrs: []attrSelector{{name: "data-x", value: "[1]", exact: true}}}, {tag: "p"}}}},
+
+		{s: "", err: true},
+		{s: " ", err: true},
+		{s: "a,", err: true},
+		{s: ",a", err: true},
+		{s: "> a", err: true},
+		{s: "a >", err: true},
+		{s: "a > > b", err: true},
+		{s: "a.", err: true},
+		{s: "#", err: true},
+		{s: "a[href", err: true},
+		{s: "a[]", err: true},
+		{s: `a[href$=".pdf"]`, err: true},
+		{s: "a[class~=nav]", err: true},
+		{s: "a:hover", err: true},
+		{s: "li:nth-child(2)", err: true},
+		{s: "h1+p", err: true},
+		{s: "h1 ~ p", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSelector(tt.s)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseSelector(%q) = %+v, want an error", tt.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSelector(%q) failed: %v", tt.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSelector(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestSelectorMatch(t *testing.T) {
+	el := func(tag string, attrs ...string) element {
+		e := element{tag: tag, attrs: map[string]string{}}
+		for i := 0; i+1 < len(attrs); i += 2 {
+			e.attrs[attrs[i]] = attrs[i+1]
+		}
+		return e
+	}
+	html := el("html")
+	body := el("body")
+	main := el("main", "id", "main", "class", "content wide")
+	div := el("div")
+	ul := el("ul", "class", "nav")
+	li := el("li")
+	a := el("a", "href", "/x", "rel", "nofollow")
+
+	tests := []struct {
+		s     string
+		stack []element
+		want  bool
+	}{
+		{"main", []element{html, body, main}, true},
+		{"main", []element{html, body, main, div}, false},
+		{"#main", []element{html, body, main}, true},
+		{".wide.content", []element{html, body, main}, true},
+		{".content.narrow", []element{html, body, main}, false},
+		{"main.wid", []element{html, body, main}, false},
+		{"a[href]", []element{html, body, a}, true},
+		{"a[title]", []element{html, body, a}, false},
+		{"a[rel=nofollow]", []element{html, body, a}, true},
+		{"a[rel=follow]", []element{html, body, a}, false},
+		{"body a", []element{html, body, main, div, a}, true},
+		{"body > a", []element{html, body, main, div, a}, false},
+		{"body > main a", []element{html, body, main, div, a}, true},
+		{"main > div > a", []element{html, body, main, div, a}, true},
+		{"main > a", []element{html, body, main, div, a}, false},
+		{"ul.nav > li a", []element{html, body, ul, li, div, a}, true},
+		{"ul.nav > li a", []element{html, body, ul, div, li, a}, false},
+		{"div main", []element{html, body, main}, false},
+		{"nav, main", []element{html, body, main}, true},
+		{"*", []element{html}, true},
+		{"main", nil, false},
+	}
+
+	for _, tt := range tests {
+		sel, err := parseSelector(tt.s)
+		if err != nil {
+			t.Fatalf("parseSelector(%q) failed: %v", tt.s, err)
+		}
+		if got := sel.match(tt.stack); got != tt.want {
+			var tags []string
+			for _, e := range tt.stack {
+				tags = append(tags, e.tag)
+			}
+			t.Errorf("%q matches %v = %v, want %v", tt.s, tags, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package searcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Result is the outcome of searching a single site.
+type Result struct {
+	// Site is the site as it appeared in the input.
+	Site string
+
+	// Index is the position of the site in the input.
+	Index int
+
+	// Columns holds the columns carried through from the input file.
+	Columns []Column
+
+	// Outcome summarises what happened when the site was searched.
+	Outcome Outcome
+
+	// Searched reports whether the page body was searched. Found,
+	// Matched, Count and Snippets are only meaningful if it was.
+	Searched bool
+
+	// Found reports whether the search query matched the page.
+	Found bool
+
+	// Matched lists the leaf terms of the query that exist on the
+	// page, in the order they first appear in the query. A term may
+	// be matched even if the query as a whole was not.
+	Matched []string
+
+	// Count is the total number of occurrences of the matched terms,
+	// up to where reading stopped if StoppedEarly is set.
+	Count int
+
+	// Regions lists the regions of the page that terms matched in,
+	// in the order they first matched, if the search was scoped to
+	// regions. See Options.Regions.
+	Regions []Region
+
+	// Snippets holds up to Options.Snippets pieces of text
+	// surrounding the first occurrences of the matched terms.
+	Snippets []Snippet
+
+	// Pages is the number of the site's pages searched (or for Fetch,
+	// fetched), if it was crawled or its sitemaps were searched. The
+	// results of searching
+	// each page are combined: Matched and Regions are merged, Count
+	// is the total over every page, and Snippets are taken from the
+	// pages in the order they were searched.
+	Pages int
+
+	// MatchedPages lists the URLs of the pages the query matched,
+	// if the site was crawled or its sitemaps were searched.
+	MatchedPages []string
+
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+
+	// Normalized is the normalized URL of the site. See NormalizeURL.
+	Normalized string
+
+	// URL is the final URL fetched, after any fallbacks and redirects.
+	URL string
+
+	// Redirects lists the redirects followed to reach URL, in order.
+	Redirects []Redirect
+
+	// Truncated means the page was larger than Options.MaxBodySize,
+	// and only the start of it was searched.
+	Truncated bool
+
+	// StoppedEarly means reading stopped once every term was found,
+	// so Count may not include every occurrence. See Options.CountAll.
+	StoppedEarly bool
+
+	// Charset is the character set the page was decoded from,
+	// e.g. "shift_jis" or "windows-1251".
+	Charset string
+
+	// Cache records whether the page was served from the cache, if
+	// there is one: "hit" if the cached page was fresh, "revalidated"
+	// if the server confirmed it was unchanged, or "miss" if it was
+	// fetched. See ClientOptions.Cache.
+	Cache string
+
+	// Duration is the time taken to fetch and search the page.
+	Duration time.Duration
+
+	// Attempts is the number of attempts made to fetch the page.
+	Attempts int
+
+	// Err is any error encountered fetching or parsing the page. Errors
+	// are of type *Error, classified by kind, except for ErrCancelled.
+	Err error
+
+	// saved lists the site's pages saved to the snapshot being saved.
+	saved []snapshotPage
+}
+
+// Outcome summarises what happened when a site was searched.
+type Outcome string
+
+const (
+	// OutcomeOK means the page was fetched with a 2xx status and searched.
+	OutcomeOK Outcome = "ok"
+
+	// OutcomeHTTPError means the server responded with a non-2xx status.
+	OutcomeHTTPError Outcome = "http_error"
+
+	// OutcomeError means the page could not be fetched or parsed.
+	OutcomeError Outcome = "error"
+
+	// OutcomeCancelled means the search was cancelled before the site finished.
+	OutcomeCancelled Outcome = "cancelled"
+
+	// OutcomeSkippedRobots means the site's robots.txt disallows the URL,
+	// so it was not fetched.
+	OutcomeSkippedRobots Outcome = "skipped_robots"
+)
+
+// outcome returns the outcome of a result based on its error.
+func outcome(r Result) Outcome {
+	switch KindOf(r.Err) {
+	case "":
+		return OutcomeOK
+	case ErrorHTTPStatus:
+		return OutcomeHTTPError
+	case ErrorCancelled:
+		return OutcomeCancelled
+	}
+	return OutcomeError
+}
+
+// Redirect is a redirect followed while fetching a page.
+type Redirect struct {
+	// URL is the URL that responded with the redirect.
+	URL string `json:"url"`
+
+	// StatusCode is the redirect's HTTP status code.
+	StatusCode int `json:"status_code"`
+}
+
+// jsonResult is the JSON encoding of a Result. The field
+// names are stable and safe for downstream tools to depend on.
+type jsonResult struct {
+	Site         string            `json:"site"`
+	Index        int               `json:"index"`
+	Columns      map[string]string `json:"columns,omitempty"`
+	Outcome      Outcome           `json:"outcome"`
+	Searched     bool              `json:"searched"`
+	Found        bool              `json:"found"`
+	Matched      []string          `json:"matched"`
+	Count        int               `json:"count"`
+	Regions      []Region          `json:"regions,omitempty"`
+	Snippets     []Snippet         `json:"snippets"`
+	Pages        int               `json:"pages_searched,omitempty"`
+	MatchedPages []string          `json:"matched_pages,omitempty"`
+	StatusCode   int               `json:"status_code,omitempty"`
+	Normalized   string            `json:"normalized_url,omitempty"`
+	URL          string            `json:"final_url,omitempty"`
+	Redirects    []Redirect        `json:"redirects,omitempty"`
+	Truncated    bool              `json:"truncated,omitempty"`
+	Stopped      bool              `json:"stopped_early,omitempty"`
+	Charset      string            `json:"charset,omitempty"`
+	Cache        string            `json:"cache,omitempty"`
+	DurationMS   int64             `json:"duration_ms"`
+	Attempts     int               `json:"attempts,omitempty"`
+	Error        string            `json:"error,omitempty"`
+	ErrorKind    ErrorKind         `json:"error_kind,omitempty"`
+}
+
+// MarshalJSON encodes the result using stable, snake_case field
+// names, with the error as a string and the duration in milliseconds.
+func (r Result) MarshalJSON() ([]byte, error) {
+	j := jsonResult{
+		Site:         r.Site,
+		Index:        r.Index,
+		Outcome:      r.Outcome,
+		Searched:     r.Searched,
+		Found:        r.Found,
+		Matched:      r.Matched,
+		Count:        r.Count,
+		Regions:      r.Regions,
+		Snippets:     r.Snippets,
+		Pages:        r.Pages,
+		MatchedPages: r.MatchedPages,
+		StatusCode:   r.StatusCode,
+		Normalized:   r.Normalized,
+		URL:          r.URL,
+		Redirects:    r.Redirects,
+		Truncated:    r.Truncated,
+		Stopped:      r.StoppedEarly,
+		Charset:      r.Charset,
+		Cache:        r.Cache,
+		DurationMS:   r.Duration.Milliseconds(),
+		Attempts:     r.Attempts,
+		ErrorKind:    KindOf(r.Err),
+	}
+	if j.Matched == nil {
+		j.Matched = []string{}
+	}
+	if j.Snippets == nil {
+		j.Snippets = []Snippet{}
+	}
+	if len(r.Columns) > 0 {
+		j.Columns = map[string]string{}
+		for _, c := range r.Columns {
+			j.Columns[c.Name] = c.Value
+		}
+	}
+	if r.Err != nil {
+		j.Error = r.Err.Error()
+	}
+	return json.Marshal(j)
+}
+
+// SortResults sorts results in place. If by is "input", results are
+// sorted by their position in the input; otherwise by names a carried
+// column, and results are sorted by its value, numerically if the
+// values are numbers. Ties keep their input order.
+func SortResults(results []Result, by string) error {
+	if by == "input" {
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i].Index < results[j].Index
+		})
+		return nil
+	}
+
+	// Look up the column's value on each result.
+	values := make(map[int]string, len(results))
+	for _, r := range results {
+		found := false
+		for _, c := range r.Columns {
+			if strings.EqualFold(c.Name, by) {
+				values[r.Index] = c.Value
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("cannot sort by %q: not a carried column", by)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := values[results[i].Index], values[results[j].Index]
+		if a == b {
+			return results[i].Index < results[j].Index
+		}
+		x, errX := strconv.ParseFloat(a, 64)
+		y, errY := strconv.ParseFloat(b, 64)
+		if errX == nil && errY == nil {
+			return x < y
+		}
+		return a < b
+	})
+	return nil
+}
@@ -0,0 +1,295 @@
+package searcher
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/timehop/golog/log"
+)
+
+// DefaultRobotsUserAgent is the default user agent used to
+// evaluate robots.txt rules.
+const DefaultRobotsUserAgent = "go-search"
+
+// maxRobotsSize caps the size of the robots.txt files that are read.
+const maxRobotsSize = 500 << 10
+
+// errDisallowed is returned by fetch when robots.txt disallows the URL,
+// or a URL it redirects to.
+var errDisallowed = errors.New("disallowed by robots.txt")
+
+// maxRedirects is the number of redirects followed, as by the http
+// package's default policy.
+const maxRedirects = 10
+
+// robotsFetchKey marks the context of a request for a robots.txt file,
+// whose redirects are followed without checking robots.txt, and which
+// takes no per-host slot from the limiter.
+type robotsFetchKey struct{}
+
+// robots holds the rules from a robots.txt file that apply to our
+// user agent, and the sitemaps it lists.
+type robots struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+	sitemaps   []string
+}
+
+// robotsRule is a single Allow or Disallow rule.
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// allowed reports whether the rules allow the given path (including
+// any query string). The most specific (longest) matching rule wins,
+// and Allow wins a tie. A path matching no rules is allowed.
+func (r *robots) allowed(path string) bool {
+	if path == "/robots.txt" {
+		return true
+	}
+
+	allow, longest := true, -1
+	for _, rule := range r.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		if n := len(rule.pattern); n > longest || (n == longest && rule.allow) {
+			allow, longest = rule.allow, n
+		}
+	}
+	return allow
+}
+
+// robotsMatch reports whether a robots.txt path pattern matches path.
+// Patterns match path prefixes; '*' matches any sequence of characters
+// and a trailing '$' anchors the pattern to the end of the path.
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+
+	// Match each remaining part at its earliest position, except the
+	// last part of an anchored pattern, which must end the path.
+	for i, part := range parts[1:] {
+		if anchored && i == len(parts)-2 {
+			return strings.HasSuffix(path[pos:], part)
+		}
+		j := strings.Index(path[pos:], part)
+		if j < 0 {
+			return false
+		}
+		pos += j + len(part)
+	}
+
+	return !anchored || pos == len(path)
+}
+
+// parseRobots takes the contents of a robots.txt file and returns
+// the rules from the group matching userAgent, or from the '*' group
+// if none match. Groups naming the same user agent are merged.
+// Sitemap lines apply to every group.
+func parseRobots(r io.Reader, userAgent string) *robots {
+	userAgent = strings.ToLower(userAgent)
+
+	var specific, wildcard robots
+	var agents, sitemaps []string
+	inRules, found := false, false
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+
+		// Sitemap lines are not part of any group.
+		if key == "sitemap" {
+			if value != "" {
+				sitemaps = append(sitemaps, value)
+			}
+			continue
+		}
+
+		// A User-agent line after some rules starts a new group.
+		if key == "user-agent" {
+			if inRules {
+				agents, inRules = nil, false
+			}
+			agents = append(agents, strings.ToLower(value))
+			continue
+		}
+
+		// Apply the line to the groups it belongs to.
+		inRules = true
+		for _, agent := range agents {
+			var group *robots
+			switch {
+			case agent == "*":
+				group = &wildcard
+			case agent != "" && strings.Contains(userAgent, agent):
+				group = &specific
+				found = true
+			default:
+				continue
+			}
+
+			switch key {
+			case "allow", "disallow":
+				// An empty Disallow allows everything, so it adds no rule.
+				if value != "" {
+					group.rules = append(group.rules, robotsRule{allow: key == "allow", pattern: value})
+				}
+			case "crawl-delay":
+				if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+					group.crawlDelay = time.Duration(secs * float64(time.Second))
+				}
+			}
+		}
+	}
+
+	// A matching group takes precedence even if it has no rules.
+	group := &wildcard
+	if found {
+		group = &specific
+	}
+	group.sitemaps = sitemaps
+	return group
+}
+
+// robotsCache fetches and caches the robots.txt file for each
+// origin (scheme and host). It is safe for concurrent use.
+type robotsCache struct {
+	mu      sync.Mutex
+	entries map[string]*robotsEntry
+}
+
+// robotsEntry is a cached robots.txt file. done is closed once
+// the file has been fetched.
+type robotsEntry struct {
+	done   chan struct{}
+	robots *robots
+}
+
+// robotsAllowed reports whether the robots.txt file for the URL's
+// origin allows it to be fetched.
+func (s *Searcher) robotsAllowed(ctx context.Context, rawURL string) (bool, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false, err
+	}
+	r, err := s.robotsFor(ctx, u)
+	if err != nil {
+		return false, err
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return r.allowed(path), nil
+}
+
+// robotsFor returns the robots.txt file for the URL's origin from the
+// cache. The file is fetched the first time an origin is seen, and
+// any Crawl-delay is applied to the host.
+func (s *Searcher) robotsFor(ctx context.Context, u *url.URL) (*robots, error) {
+	origin := u.Scheme + "://" + u.Host
+
+	// Claim the origin's entry, or wait for another goroutine to fetch it.
+	c := s.robots
+	c.mu.Lock()
+	entry, ok := c.entries[origin]
+	if !ok {
+		entry = &robotsEntry{done: make(chan struct{})}
+		c.entries[origin] = entry
+	}
+	c.mu.Unlock()
+
+	if ok {
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	} else {
+		entry.robots = s.fetchRobots(ctx, origin)
+		close(entry.done)
+
+		if d := entry.robots.crawlDelay; d > 0 && s.limiter != nil {
+			log.Debug("go-search", fmt.Sprintf("Applying Crawl-delay of %s to %s", d, u.Host))
+			s.limiter.setHostDelay(u.Hostname(), d)
+		}
+	}
+
+	return entry.robots, nil
+}
+
+// fetchRobots fetches and parses the robots.txt file for an origin.
+// A missing file (4xx) allows everything and a server error (5xx)
+// disallows everything. If the file can't be fetched at all,
+// everything is allowed so that the page fetch reports the error.
+func (s *Searcher) fetchRobots(ctx context.Context, origin string) *robots {
+	disallowAll := &robots{rules: []robotsRule{{allow: false, pattern: "/"}}}
+
+	response, err := s.get(context.WithValue(ctx, robotsFetchKey{}, true), origin+"/robots.txt")
+	if err != nil {
+		log.Debug("go-search", fmt.Sprintf("Could not fetch robots.txt for %s, allowing all.", origin), "error", err)
+		return &robots{}
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 500 {
+		log.Debug("go-search", fmt.Sprintf("robots.txt for %s returned %s, disallowing all.", origin, response.Status))
+		return disallowAll
+	}
+	if response.StatusCode != http.StatusOK {
+		return &robots{}
+	}
+
+	return parseRobots(io.LimitReader(response.Body, maxRobotsSize), s.opts.RobotsUserAgent)
+}
+
+// checkRedirect is the http client's redirect policy when robots.txt
+// is respected: it stops at a redirect to a URL robots.txt disallows,
+// as well as after maxRedirects redirects.
+func (s *Searcher) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if req.Context().Value(robotsFetchKey{}) != nil {
+		return nil
+	}
+	allowed, err := s.robotsAllowed(req.Context(), req.URL.String())
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		log.Debug("go-search", fmt.Sprintf("Not following the redirect from %s to %s: disallowed by robots.txt.", via[len(via)-1].URL, req.URL))
+		return errDisallowed
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package searcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRobotsMatch(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/", "/anything", true},
+		{"/private", "/private/page", true},
+		{"/private", "/privately", true},
+		{"/private/", "/private", false},
+		{"/private", "/public", false},
+		{"/*.pdf", "/docs/a.pdf", true},
+		{"/*.pdf", "/docs/a.pdf?x=1", true},
+		{"/*.pdf$", "/docs/a.pdf", true},
+		{"/*.pdf$", "/docs/a.pdf?x=1", false},
+		{"/a*b*c", "/aXbYc", true},
+		{"/a*b*c", "/aXcYb", false},
+		{"/exact$", "/exact", true},
+		{"/exact$", "/exactly", false},
+		{"/*?", "/page?q=1", true},
+		{"/*?", "/page", false},
+	}
+	for _, tt := range tests {
+		if got := robotsMatch(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("robotsMatch(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseRobots(t *testing.T) {
+	file := `# A comment
+User-agent: *
+Disallow: /private
+Allow: /private/public
+Crawl-delay: 2
+
+User-agent: go-search
+User-agent: other
+Disallow: /go-search-only   # trailing comment
+Disallow:
+
+Sitemap: https://example.com/sitemap.xml
+
+User-agent: GO-SEARCH
+Crawl-delay: 0.5
+Sitemap: https://example.com/news.xml
+`
+	tests := []struct {
+		userAgent string
+		want      robots
+	}{
+		{
+			userAgent: "go-search",
+			want: robots{
+				rules:      []robotsRule{{allow: false, pattern: "/go-search-only"}},
+				crawlDelay: 500 * time.Millisecond,
+				sitemaps:   []string{"https://example.com/sitemap.xml", "https://example.com/news.xml"},
+			},
+		},
+		{
+			userAgent: "SomeBot",
+			want: robots{
+				rules:      []robotsRule{{allow: false, pattern: "/private"}, {allow: true, pattern: "/private/public"}},
+				crawlDelay: 2 * time.Second,
+				sitemaps:   []string{"https://example.com/sitemap.xml", "https://example.com/news.xml"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got := parseRobots(strings.NewReader(file), tt.userAgent)
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("parseRobots for %q = %+v, want %+v", tt.userAgent, *got, tt.want)
+		}
+	}
+}
+
+func TestRobotsAllowed(t *testing.T) {
+	r := parseRobots(strings.NewReader(`User-agent: *
+Disallow: /private
+Allow: /private/public
+Disallow: /*.pdf$
+Allow: /tie
+Disallow: /tie
+Disallow: /
+Allow: /$
+`), "go-search")
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/", true},
+		{"/robots.txt", true},
+		{"/page", false},
+		{"/private/page", false},
+		{"/private/public/page", true},
+		{"/tie", true},
+		{"/a.pdf", false},
+	}
+	for _, tt := range tests {
+		if got := r.allowed(tt.path); got != tt.want {
+			t.Errorf("allowed(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSearchRespectsRobots(t *testing.T) {
+	var private int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+	})
+	mux.HandleFunc("/public", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<p>needle</p>"))
+	})
+	mux.HandleFunc("/private/", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&private, 1)
+		w.Write([]byte("<p>needle</p>"))
+	})
+	mux.HandleFunc("/to-private", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/private/page", http.StatusFound)
+	})
+	mux.HandleFunc("/to-public", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/public", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	s := New(Options{RespectRobots: true})
+	results, err := s.Search(context.Background(), []string{"needle"}, []string{
+		server.URL + "/public",
+		server.URL + "/private/page",
+		server.URL + "/to-private",
+		server.URL + "/to-public",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]Outcome{
+		"/public":       OutcomeOK,
+		"/private/page": OutcomeSkippedRobots,
+		"/to-private":   OutcomeSkippedRobots,
+		"/to-public":    OutcomeOK,
+	}
+	for _, result := range results {
+		path := strings.TrimPrefix(result.Site, server.URL)
+		if result.Outcome != want[path] {
+			t.Errorf("%s: outcome %q, want %q (error %v)", path, result.Outcome, want[path], result.Err)
+		}
+	}
+	if n := atomic.LoadInt32(&private); n != 0 {
+		t.Errorf("disallowed pages were fetched %d times", n)
+	}
+}
+
+func TestSearchRobotsRedirectWithHostLimit(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+			return
+		}
+		w.Write([]byte("<p>needle</p>"))
+	}))
+	defer target.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, target.URL+r.URL.Path, http.StatusFound)
+	}))
+	defer origin.Close()
+
+	// Both servers are on the same host as far as the limiter is
+	// concerned, so checking the robots.txt for a redirect's target
+	// happens while the redirected request holds the host's slot.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s := New(Options{RespectRobots: true, MaxRequests: 2, Limits: RateLimits{PerHost: 1}})
+	results, err := s.Search(ctx, []string{"needle"}, []string{
+		origin.URL + "/page",
+		origin.URL + "/private",
+		target.URL + "/other",
+	})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+
+	want := map[string]Outcome{
+		origin.URL + "/page":    OutcomeOK,
+		origin.URL + "/private": OutcomeSkippedRobots,
+		target.URL + "/other":   OutcomeOK,
+	}
+	for _, result := range results {
+		if result.Outcome != want[result.Site] {
+			t.Errorf("%s: outcome %q, want %q (error %v)", result.Site, result.Outcome, want[result.Site], result.Err)
+		}
+	}
+}
+
+func TestFetchRobotsStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   bool
+	}{
+		{http.StatusOK, "User-agent: *\nDisallow: /\n", false},
+		{http.StatusOK, "User-agent: other\nDisallow: /\n", true},
+		{http.StatusNotFound, "", true},
+		{http.StatusServiceUnavailable, "", false},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/robots.txt" {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}
+		}))
+
+		s := New(Options{RespectRobots: true})
+		allowed, err := s.robotsAllowed(context.Background(), server.URL+"/page")
+		if err != nil {
+			t.Error(err)
+		} else if allowed != tt.want {
+			t.Errorf("with robots.txt %d %q, allowed = %v, want %v", tt.status, tt.body, allowed, tt.want)
+		}
+		server.Close()
+	}
+}
+
+func TestFetchRobotsFollowsRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/robots-real.txt", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/robots-real.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("User-agent: *\nDisallow: /page\n"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s := New(Options{RespectRobots: true})
+	allowed, err := s.robotsAllowed(ctx, server.URL+"/page")
+	if err != nil || allowed {
+		t.Errorf("robotsAllowed = %v, %v, want false", allowed, err)
+	}
+}
@@ -0,0 +1,617 @@
+// Package searcher fetches a list of websites concurrently and
+// determines whether a search term exists on each page.
+package searcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/timehop/golog/log"
+)
+
+// DefaultMaxRequests is the default maximum number of concurrent
+// requests to be executed.
+const DefaultMaxRequests = 20
+
+// DefaultTimeout is the default timeout for each http request.
+const DefaultTimeout = 8 * time.Second
+
+// DefaultMaxBodySize is the default maximum number of bytes read
+// from each response body.
+const DefaultMaxBodySize = 10 << 20
+
+// ErrNoTerm is returned by Search when no search term was provided.
+var ErrNoTerm = errors.New("no search term was provided")
+
+// ErrCancelled is recorded on the results of sites that did not
+// finish because the search was cancelled.
+var ErrCancelled = errors.New("cancelled")
+
+// Options configures a Searcher. The zero value is usable; any
+// unset fields fall back to their defaults.
+type Options struct {
+	// MaxRequests is the maximum number of concurrent requests.
+	MaxRequests int
+
+	// Timeout is the timeout for each http request.
+	Timeout time.Duration
+
+	// Client configures the http client: headers, cookies, proxy,
+	// TLS and finer-grained timeouts.
+	Client ClientOptions
+
+	// Mode determines how search terms are matched.
+	Mode Mode
+
+	// Normalization is the Unicode normalization form applied to page
+	// text and terms before they are compared, after case folding.
+	// It applies to the substring and whole-word modes.
+	Normalization Normalization
+
+	// IgnoreAccents matches terms regardless of accents and other
+	// diacritics, so that "cafe" matches "café". It applies to the
+	// substring and whole-word modes.
+	IgnoreAccents bool
+
+	// Snippets is the maximum number of snippets of surrounding text
+	// recorded for each result. If zero, no snippets are recorded.
+	// Unless CountAll is set, reading stops once every term is found,
+	// so there may be fewer snippets than there are occurrences.
+	Snippets int
+
+	// SnippetWindow is the number of bytes of surrounding text
+	// included on each side of a match in a snippet.
+	SnippetWindow int
+
+	// Retry determines how failed requests are retried. Any unset
+	// fields are taken from DefaultRetryPolicy.
+	Retry RetryPolicy
+
+	// Limits controls how quickly requests are made, per host and overall.
+	Limits RateLimits
+
+	// RespectRobots fetches each host's robots.txt and skips any URLs
+	// it disallows, or that redirect to URLs it disallows, recording
+	// them with OutcomeSkippedRobots. Any
+	// Crawl-delay is applied as the minimum delay between requests
+	// to the host.
+	RespectRobots bool
+
+	// RobotsUserAgent is the user agent used to select the rules
+	// in robots.txt files.
+	RobotsUserAgent string
+
+	// Target determines which part of each page is searched: its
+	// text, raw HTML, tag attributes or scripts.
+	Target Target
+
+	// Regions scopes searches to the given regions of each page, and
+	// the regions matched in are recorded on each result. If empty,
+	// the page body is searched.
+	Regions []Region
+
+	// Selector scopes searches to the elements matching a CSS
+	// selector, as RegionSelector, in addition to any Regions. Type,
+	// class, ID and attribute selectors are supported, combined with
+	// the descendant and child combinators. Regions and Selector
+	// only apply to TargetText.
+	Selector string
+
+	// MaxBodySize is the maximum number of bytes read from each
+	// response body. Larger pages are searched up to the limit and
+	// marked as Truncated.
+	MaxBodySize int64
+
+	// CountAll reads every page to the end so that Count includes
+	// every occurrence. Otherwise reading stops once every term has
+	// been found.
+	CountAll bool
+
+	// SearchErrorPages searches the body of non-2xx responses, which
+	// are otherwise recorded with an ErrorHTTPStatus error and not searched.
+	SearchErrorPages bool
+
+	// Crawl follows links from each site's page to search further
+	// pages on the same site. A site is found if any of its pages
+	// match. See CrawlOptions.
+	Crawl CrawlOptions
+
+	// Sitemaps searches the pages listed in each site's sitemaps,
+	// combining them with any crawled pages. See SitemapOptions.
+	Sitemaps SitemapOptions
+
+	// Snapshot, if set, is searched instead of fetching pages from the
+	// network. Sites are looked up by their normalized URL, and the
+	// pages saved for them are searched. Crawl, Sitemaps and the
+	// options affecting requests do not apply. Unless the snapshot
+	// holds each page's HTML, only the default Target and Regions can
+	// be used. See OpenSnapshot.
+	Snapshot *Snapshot
+
+	// SaveSnapshot, if set, saves every page fetched to a snapshot,
+	// so that it can be searched later. See CreateSnapshot and Fetch.
+	SaveSnapshot *Snapshot
+
+	// Progress, if set, is called once for each url processed.
+	Progress func(site string)
+
+	// OnResult, if set, is called with each result as it arrives,
+	// allowing results to be streamed before Search returns. Calls
+	// are made from a single goroutine.
+	OnResult func(result Result)
+}
+
+// Searcher fetches pages and searches their content. A Searcher
+// is safe for concurrent use by multiple goroutines.
+type Searcher struct {
+	opts   Options
+	client *http.Client
+
+	// limiter enforces opts.Limits and any robots.txt Crawl-delay,
+	// or is nil if there are neither.
+	limiter *limiter
+
+	// robots caches robots.txt files if opts.RespectRobots is set.
+	robots *robotsCache
+}
+
+// New returns a Searcher configured with the given options.
+func New(opts Options) *Searcher {
+	if opts.MaxRequests <= 0 {
+		opts.MaxRequests = DefaultMaxRequests
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DefaultMaxBodySize
+	}
+	if opts.SnippetWindow <= 0 {
+		opts.SnippetWindow = DefaultSnippetWindow
+	}
+	if opts.Client.UserAgent == "" {
+		opts.Client.UserAgent = DefaultUserAgent
+	}
+	if opts.Crawl.MaxPages <= 0 {
+		opts.Crawl.MaxPages = DefaultCrawlPages
+	}
+	if opts.Sitemaps.MaxPages <= 0 {
+		opts.Sitemaps.MaxPages = DefaultSitemapPages
+	}
+	if opts.RobotsUserAgent == "" {
+		opts.RobotsUserAgent = DefaultRobotsUserAgent
+	}
+	opts.Retry = opts.Retry.withDefaults()
+
+	// Create a single http Client for the Searcher.
+	// From the docs: "Clients should be reused instead of created as
+	// needed. Clients are safe for concurrent use by multiple goroutines."
+	client := newClient(opts.Client, opts.Timeout)
+
+	s := &Searcher{
+		opts:   opts,
+		client: client,
+	}
+	if opts.Limits.enabled() || opts.RespectRobots {
+		s.limiter = newLimiter(opts.Limits)
+	}
+	if opts.RespectRobots {
+		s.robots = &robotsCache{entries: map[string]*robotsEntry{}}
+		client.CheckRedirect = s.checkRedirect
+	}
+
+	return s
+}
+
+// Search takes a slice of search terms and a slice of URLs, fetches
+// the page content for each URL, performs a search, and then returns
+// a slice of results containing the result and any errors encountered.
+//
+// Each term is a query expression which may combine terms with AND,
+// OR, NOT, parentheses and quoted phrases. A site is found if any of
+// the expressions match.
+//
+// If ctx is cancelled, in-flight requests are aborted and Search returns
+// the results gathered so far along with ctx.Err(). Sites that did not
+// finish are included with Err set to ErrCancelled.
+func (s *Searcher) Search(ctx context.Context, terms []string, urls []string) ([]Result, error) {
+	records := make([]Record, len(urls))
+	for i, site := range urls {
+		records[i] = Record{URL: site}
+	}
+	return s.SearchRecords(ctx, terms, records)
+}
+
+// SearchRecords is like Search, but takes a slice of records read
+// from an input file. Each record's columns are carried through to
+// its result.
+func (s *Searcher) SearchRecords(ctx context.Context, terms []string, records []Record) ([]Result, error) {
+
+	// Parse the search terms into a single query, skipping any empty terms.
+	q, err := parseQueries(terms, s.opts.Mode, newFolder(s.opts.Normalization, s.opts.IgnoreAccents))
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("go-search", fmt.Sprintf("Parsed query: %s", q.root))
+
+	// Work out which regions of each page to search.
+	sc, err := s.scope()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.run(ctx, q, sc, records)
+}
+
+// Fetch fetches the page for each record, along with any further pages
+// of its site if crawling, and saves them to Options.SaveSnapshot
+// without searching them, so that they can be searched later without
+// network access using Options.Snapshot. It returns the outcome of
+// fetching each site, and like Search, returns early if ctx is cancelled.
+func (s *Searcher) Fetch(ctx context.Context, records []Record) ([]Result, error) {
+	if s.opts.SaveSnapshot == nil {
+		return nil, errors.New("no snapshot to save pages to")
+	}
+	return s.run(ctx, nil, scope{}, records)
+}
+
+// run fetches and searches the sites of the records concurrently,
+// evaluating the query in the given scope, or if q is nil, only
+// fetching them to save them to a snapshot.
+func (s *Searcher) run(ctx context.Context, q *query, sc scope, records []Record) ([]Result, error) {
+
+	// Create a chan of jobs to send work to be processed (records).
+	// Create a chan of type Result to send results.
+	// Set up a WaitGroup so we can track when all goroutines have finished processing.
+	ch := make(chan job)
+	done := make(chan Result)
+	var wg sync.WaitGroup
+
+	// If there are less than MaxRequests records, decrease the number of
+	// workers to the number of records to avoid spinning up unnecessary
+	// goroutines. The pages in sites' sitemaps are searched by the
+	// workers too, so they may be needed whatever the number of records.
+	workers := s.opts.MaxRequests
+	if workers > len(records) && !s.opts.Sitemaps.Enabled {
+		workers = len(records)
+	}
+
+	// Each worker reports on the feedback chan when it finishes a job,
+	// sending any further jobs it created: the pages in a site's sitemaps.
+	feedback := make(chan []job)
+
+	log.Info("go-search", "Fetching and searching urls...")
+
+	// Spin up 'workers' number of goroutines.
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+
+			// Receive work from the chan of jobs until it is closed,
+			// at which point there is no more work to be done and we can return.
+			for j := range ch {
+				if j.site != nil {
+					if result, ok := s.searchSitemapPage(ctx, q, sc, j.site, j.page); ok {
+						done <- result
+					}
+					feedback <- nil
+					continue
+				}
+
+				if s.opts.Progress != nil {
+					s.opts.Progress(j.record.URL)
+				}
+				run := &siteRun{job: j, start: time.Now()}
+				run.result = s.searchSite(ctx, q, sc, j.record.URL, run)
+
+				// Hand the pages in the site's sitemaps to the workers,
+				// delivering the site's result once they are searched.
+				if len(run.pages) == 0 {
+					done <- s.finishSite(ctx, run)
+					feedback <- nil
+					continue
+				}
+				more := make([]job, len(run.pages))
+				for i, u := range run.pages {
+					more[i] = job{index: j.index, site: run, page: u}
+				}
+				run.pending = len(more)
+				feedback <- more
+			}
+		}()
+	}
+
+	// Send work to be processed as goroutines become available. The
+	// pages in sites' sitemaps are sent ahead of further sites, so that
+	// sites finish in turn. Once the context is cancelled, no further
+	// sites are sent, but the pages already queued are, so that their
+	// sites' results are still delivered.
+	go func() {
+		defer close(ch)
+		var queue []job
+		next, pending := 0, 0
+		cancelled := ctx.Done()
+		for len(queue) > 0 || pending > 0 || (next < len(records) && ctx.Err() == nil) {
+			var out chan job
+			var j job
+			if len(queue) > 0 {
+				out, j = ch, queue[0]
+			} else if next < len(records) && ctx.Err() == nil {
+				out, j = ch, job{index: next, record: records[next]}
+			}
+
+			select {
+			case out <- j:
+				pending++
+				if len(queue) > 0 {
+					queue = queue[1:]
+				} else {
+					log.Debug("go-search", fmt.Sprintf("Sending work: %s", j.record.URL))
+					next++
+				}
+			case more := <-feedback:
+				pending--
+				queue = append(queue, more...)
+			case <-cancelled:
+				log.Debug("go-search", "Search cancelled, no more work will be sent")
+				cancelled = nil
+			}
+		}
+	}()
+
+	// Close the done chan once the goroutines are done processing.
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Receive the results on the done chan.
+	results := []Result{}
+	finished := make([]bool, len(records))
+	for result := range done {
+		log.Debug("go-search", fmt.Sprintf("Receiving result: %s", result.Site))
+		s.deliver(result)
+		results = append(results, result)
+		finished[result.Index] = true
+	}
+
+	// Mark any sites that were never processed as cancelled.
+	for i, record := range records {
+		if !finished[i] {
+			result := Result{Site: record.URL, Index: i, Columns: record.Columns, Outcome: OutcomeCancelled, Err: ErrCancelled}
+			s.deliver(result)
+			results = append(results, result)
+		}
+	}
+
+	return results, ctx.Err()
+}
+
+// deliver passes a result to the OnResult callback, if set.
+func (s *Searcher) deliver(result Result) {
+	if s.opts.OnResult != nil {
+		s.opts.OnResult(result)
+	}
+}
+
+// job is a unit of work sent to the worker goroutines. The
+// index records the position of the record in the input. If site
+// is set, the job is to search a page in the site's sitemaps.
+type job struct {
+	index  int
+	record Record
+
+	site *siteRun
+	page *url.URL
+}
+
+// finishSite completes a site's result once all of its pages have
+// been searched, saving it to the snapshot being saved, if any.
+func (s *Searcher) finishSite(ctx context.Context, run *siteRun) Result {
+	j, result := run.job, run.result
+
+	// If the search was cancelled while this site or the pages in its
+	// sitemaps were in flight, record it as cancelled rather than as a
+	// fetch error or with only some of its pages.
+	if (result.Err != nil || run.cancelled) && ctx.Err() != nil {
+		result = Result{Site: j.record.URL, Err: ErrCancelled}
+	}
+	result.Duration = time.Since(run.start)
+	if result.Outcome == "" {
+		result.Outcome = outcome(result)
+	}
+	result.Index = j.index
+	result.Columns = j.record.Columns
+
+	// Record the site in the snapshot being saved, if any.
+	if s.opts.SaveSnapshot != nil && result.Outcome != OutcomeCancelled {
+		if err := s.opts.SaveSnapshot.addSite(result); err != nil {
+			log.Error("go-search", fmt.Sprintf("Error saving %s to the snapshot", result.Site), "error", err)
+		}
+	}
+	return result
+}
+
+// searchSite fetches the page content for a single site and
+// evaluates the query against the regions of it in scope. If
+// searching a snapshot, the page content is read from the snapshot.
+// The pages in the site's sitemaps are not searched, but are recorded
+// on run to be searched by the workers.
+func (s *Searcher) searchSite(ctx context.Context, q *query, sc scope, site string, run *siteRun) Result {
+	if s.opts.Snapshot != nil {
+		return s.searchSnapshot(q, sc, site)
+	}
+	result := Result{Site: site}
+
+	// Normalize the site to work out which URLs to fetch.
+	u, explicit, err := NormalizeURL(site)
+	if err != nil {
+		result.Err = classifyError(ErrorURL, err)
+		return result
+	}
+	result.Normalized = u.String()
+
+	// Fetch the page content, retrying according to the retry policy.
+	response, attempts, err := s.fetch(ctx, u, explicit)
+	result.Attempts = attempts
+	if err == errDisallowed {
+		log.Debug("go-search", fmt.Sprintf("Skipping %s: disallowed by robots.txt.", site))
+
+		result.Outcome = OutcomeSkippedRobots
+		return result
+	}
+	if err != nil {
+		log.Debug("go-search", fmt.Sprintf("All requests failed for %s, returning an error.", site), "error", err)
+
+		result.Err = err
+		return result
+	}
+
+	// Record the status code, the final URL and any redirects followed.
+	result.StatusCode = response.StatusCode
+	result.URL = response.Request.URL.String()
+	result.Redirects = redirects(response)
+	result.Cache = response.Header.Get(cacheStatusHeader)
+
+	// Record non-2xx responses as errors. Error pages are usually not
+	// the real content (e.g. a 404 or a bot-block page), so only search
+	// them if asked to. Pages fetched only to save them to a snapshot
+	// are saved whatever their status, so they can be searched later.
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		result.Err = classifyError(ErrorHTTPStatus, errors.New(response.Status))
+		if !s.opts.SearchErrorPages && q != nil {
+			response.Body.Close()
+			return result
+		}
+	}
+
+	// Search the page, collecting its links if the site is to be crawled.
+	start := response.Request.URL
+	var found *links
+	if s.opts.Crawl.enabled() && result.Err == nil {
+		found = newLinks(start)
+	}
+	if err := s.searchPage(q, sc, response, &result, found); err != nil {
+		result.Err = err
+		return result
+	}
+
+	if found == nil && !(s.opts.Sitemaps.Enabled && result.Err == nil) {
+		return result
+	}
+
+	// Follow the links to search the rest of the site, and find the
+	// pages in its sitemaps. The site's URL and the page it redirected
+	// to are both seen.
+	c := newCrawler(s.opts.Crawl, start)
+	if u, err := url.Parse(result.Normalized); err == nil {
+		c.seen[crawlKey(u)] = true
+	}
+	firstPage(&result)
+	if found != nil {
+		s.crawl(ctx, q, sc, &result, c, found)
+	}
+	if s.opts.Sitemaps.Enabled && result.Err == nil && ctx.Err() == nil {
+		run.crawler = c
+		run.pages = s.sitemapPages(ctx, start, s.opts.Sitemaps.MaxPages, c.visit)
+	}
+	return result
+}
+
+// searchPage searches the body of a response, closing it, and
+// records the outcome on the result. If l is set, the targets of
+// the page's links are added to it. If a snapshot is being saved,
+// the page is saved to it, and if q is nil, it is only saved.
+func (s *Searcher) searchPage(q *query, sc scope, response *http.Response, result *Result, l *links) error {
+	defer response.Body.Close()
+
+	// Decode the response to UTF-8 according to its character set.
+	body, name, err := decodeBody(response.Body, response.Header.Get("Content-Type"))
+	if err != nil {
+		return classifyError(ErrorBody, err)
+	}
+	result.Charset = name
+	r := io.LimitReader(body, s.opts.MaxBodySize)
+
+	// To save the page to a snapshot, read all of it, up to
+	// MaxBodySize bytes, and then search the copy read. Its
+	// links are collected as it is saved.
+	sn := s.opts.SaveSnapshot
+	if sn != nil {
+		page, err := io.ReadAll(r)
+		if err != nil {
+			return classifyError(ErrorBody, err)
+		}
+		result.Truncated = hasMore(body)
+
+		saved, err := sn.savePage(result.URL, page, l)
+		if err != nil {
+			return classifyError(ErrorOther, fmt.Errorf("saving to the snapshot: %v", err))
+		}
+		saved.Charset = name
+		saved.Truncated = result.Truncated
+		result.saved = append(result.saved, saved)
+
+		if q == nil {
+			return nil
+		}
+		r, l = bytes.NewReader(page), nil
+	}
+
+	// Extract the searched part of the response, reading no more than
+	// MaxBodySize bytes, and match the query against it as it
+	// arrives. Stop reading once the result can't change, unless
+	// every occurrence is to be counted or the links are needed.
+	st := newStream(q, s.opts.Snippets, s.opts.SnippetWindow, sc.report)
+	stopped, err := extractText(r, st, sc, l, !s.opts.CountAll && l == nil)
+	if err != nil {
+		return classifyError(ErrorBody, err)
+	}
+
+	// If the response is being cached or recorded to a WARC file,
+	// read the rest of it, up to MaxBodySize bytes, so that it is
+	// kept whole.
+	read := !stopped
+	if stopped && (s.opts.Client.Cache != nil || s.opts.Client.WARC != nil) {
+		_, err := io.Copy(io.Discard, r)
+		read = err == nil
+	}
+	if read && sn == nil {
+		// If there is more to read, the body was too large.
+		result.Truncated = hasMore(body)
+	}
+	result.Searched = true
+	result.StoppedEarly = stopped
+
+	// Record which terms matched, how often, and where.
+	st.finish(result)
+	return nil
+}
+
+// hasMore reports whether there is more to read from r.
+func hasMore(r io.Reader) bool {
+	var b [1]byte
+	n, _ := r.Read(b[:])
+	return n > 0
+}
+
+// redirects returns the redirects followed to reach a response, in
+// the order they were followed.
+func redirects(response *http.Response) []Redirect {
+	var chain []Redirect
+	for req := response.Request; req.Response != nil; req = req.Response.Request {
+		chain = append([]Redirect{{
+			URL:        req.Response.Request.URL.String(),
+			StatusCode: req.Response.StatusCode,
+		}}, chain...)
+	}
+	return chain
+}
@@ -0,0 +1,47 @@
+package searcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSearchTruncated(t *testing.T) {
+	tests := []struct {
+		contentType string
+		size        int
+		countAll    bool
+		found       bool
+		truncated   bool
+	}{
+		{"text/html; charset=utf-8", 400, true, true, false},
+		{"text/html; charset=utf-8", 800, true, false, true},
+		{"text/html; charset=utf-8", 3000, true, false, true},
+		{"text/html; charset=utf-8", 3000, false, false, true},
+		{"text/html; charset=windows-1252", 400, true, true, false},
+		{"text/html; charset=windows-1252", 800, true, false, true},
+		{"text/html; charset=windows-1252", 3000, false, false, true},
+		{"text/html", 800, true, false, true},
+	}
+
+	for _, tt := range tests {
+		body := "<p>" + strings.Repeat("a ", tt.size/2) + " needle</p>"
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", tt.contentType)
+			w.Write([]byte(body))
+		}))
+
+		s := New(Options{MaxBodySize: 500, CountAll: tt.countAll})
+		results, err := s.Search(context.Background(), []string{"needle"}, []string{server.URL})
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := results[0]; r.Found != tt.found || r.Truncated != tt.truncated {
+			t.Errorf("%q page of %d bytes (count all %v): found %v, truncated %v; want found %v, truncated %v",
+				tt.contentType, len(body), tt.countAll, r.Found, r.Truncated, tt.found, tt.truncated)
+		}
+	}
+}
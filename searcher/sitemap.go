@@ -0,0 +1,233 @@
+package searcher
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/timehop/golog/log"
+)
+
+// DefaultSitemapPages is the default maximum number of pages listed
+// in each site's sitemaps that are searched.
+const DefaultSitemapPages = 100
+
+// maxSitemaps caps the number of sitemap and sitemap index files
+// read for each site.
+const maxSitemaps = 20
+
+// SitemapOptions determines how sites' sitemaps are used.
+type SitemapOptions struct {
+	// Enabled searches the pages listed in each site's sitemaps along
+	// with its page. The sitemaps are those listed in the site's
+	// robots.txt, or else /sitemap.xml. Sitemap index files are
+	// followed, and gzipped sitemaps are decompressed. The pages are
+	// searched concurrently by the same workers as the sites, and the
+	// links on them are not followed.
+	Enabled bool
+
+	// MaxPages is the maximum number of pages listed in each site's
+	// sitemaps that are searched.
+	MaxPages int
+}
+
+// siteRun tracks a site whose result is delivered once the pages in
+// its sitemaps, searched by the workers, have all been searched.
+type siteRun struct {
+	job   job
+	start time.Time
+
+	// pages are the pages in the site's sitemaps, and crawler tracks
+	// the site's pages already seen.
+	pages   []*url.URL
+	crawler *crawler
+
+	// mu guards the site's result, to which the outcome of each page
+	// is added, the crawler, and the number of pages still pending.
+	mu      sync.Mutex
+	result  Result
+	pending int
+
+	// cancelled is set if a page wasn't searched because the search
+	// was cancelled, in which case the site didn't finish.
+	cancelled bool
+}
+
+// visit marks a URL on the site as seen, as crawler.visit.
+func (run *siteRun) visit(u *url.URL) bool {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	return run.crawler.visit(u)
+}
+
+// searchSitemapPage searches a page in a site's sitemaps and adds the
+// outcome to the site's result. Once every page has been searched, it
+// returns the site's result, and true.
+func (s *Searcher) searchSitemapPage(ctx context.Context, q *query, sc scope, run *siteRun, u *url.URL) (Result, bool) {
+	page, _, ok := s.crawlPage(ctx, q, sc, run.job.record.URL, u, run.visit, false)
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if ok {
+		mergePage(q, s.opts.Snippets, &run.result, page)
+	} else if ctx.Err() != nil {
+		run.cancelled = true
+	}
+	run.pending--
+	if run.pending > 0 {
+		return Result{}, false
+	}
+	return s.finishSite(ctx, run), true
+}
+
+// sitemapPages takes the URL of a site's page and returns up to max
+// URLs of pages listed in the site's sitemaps, for which keep returns
+// true. Sitemaps that can't be read are skipped.
+func (s *Searcher) sitemapPages(ctx context.Context, site *url.URL, max int, keep func(u *url.URL) bool) []*url.URL {
+	origin := site.Scheme + "://" + site.Host
+
+	// Find the sitemaps from the site's robots.txt, using the cached
+	// file if robots.txt is respected.
+	var r *robots
+	if s.robots != nil {
+		r, _ = s.robotsFor(ctx, site)
+	} else {
+		r = s.fetchRobots(ctx, origin)
+	}
+	queue := []string{origin + "/sitemap.xml"}
+	if r != nil && len(r.sitemaps) > 0 {
+		queue = r.sitemaps
+	}
+
+	seen := map[string]bool{}
+	for _, loc := range queue {
+		seen[loc] = true
+	}
+
+	// Read the sitemaps in turn, adding the sitemaps listed in
+	// index files to the queue.
+	var pages []*url.URL
+	for read := 0; len(queue) > 0 && read < maxSitemaps && len(pages) < max && ctx.Err() == nil; read++ {
+		loc := queue[0]
+		queue = queue[1:]
+
+		base, err := url.Parse(loc)
+		if err != nil {
+			log.Debug("go-search", fmt.Sprintf("Skipping invalid sitemap URL %q for %s.", loc, origin), "error", err)
+			continue
+		}
+
+		err = s.readSitemap(ctx, loc, func(entry string, index bool) bool {
+			u, err := base.Parse(entry)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return true
+			}
+			u.Fragment = ""
+			u.RawFragment = ""
+
+			if index {
+				if !seen[u.String()] {
+					seen[u.String()] = true
+					queue = append(queue, u.String())
+				}
+				return true
+			}
+			if keep(u) {
+				pages = append(pages, u)
+			}
+			return len(pages) < max
+		})
+		if err != nil {
+			log.Debug("go-search", fmt.Sprintf("Could not read sitemap %s.", loc), "error", err)
+		}
+	}
+
+	log.Debug("go-search", fmt.Sprintf("Found %d pages in the sitemaps for %s.", len(pages), origin))
+	return pages
+}
+
+// readSitemap fetches a sitemap or sitemap index file, decompressing
+// it if it is gzipped, and passes each location it lists to visit.
+// It reads no more than MaxBodySize bytes of the (decompressed) file.
+func (s *Searcher) readSitemap(ctx context.Context, loc string, visit func(loc string, index bool) bool) error {
+	response, err := s.get(ctx, loc)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", response.Status)
+	}
+
+	// Gzipped sitemaps are detected by their magic number, as they
+	// are served with a variety of content types.
+	br := bufio.NewReader(io.LimitReader(response.Body, s.opts.MaxBodySize))
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = io.LimitReader(gz, s.opts.MaxBodySize)
+	}
+
+	return parseSitemap(r, visit)
+}
+
+// parseSitemap reads a sitemap or sitemap index file from r, passing
+// the location of each page or sitemap it lists to visit, until visit
+// returns false. The locations in extensions such as image sitemaps
+// are ignored.
+func parseSitemap(r io.Reader, visit func(loc string, index bool) bool) error {
+	d := xml.NewDecoder(r)
+
+	// parent is the <url> or <sitemap> element being read.
+	var parent xml.Name
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if end, ok := tok.(xml.EndElement); ok && end.Name == parent {
+			parent = xml.Name{}
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch el.Name.Local {
+		case "url", "sitemap":
+			parent = el.Name
+
+		case "loc":
+			if parent.Local == "" || el.Name.Space != parent.Space {
+				continue
+			}
+			var loc string
+			if err := d.DecodeElement(&loc, &el); err != nil {
+				return err
+			}
+			loc = strings.TrimSpace(loc)
+			if loc == "" {
+				continue
+			}
+			if !visit(loc, parent.Local == "sitemap") {
+				return nil
+			}
+		}
+	}
+}
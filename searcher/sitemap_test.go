@@ -0,0 +1,212 @@
+package searcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseSitemap(t *testing.T) {
+	tests := []struct {
+		name  string
+		xml   string
+		limit int
+		want  []string
+		err   bool
+	}{
+		{
+			name: "urlset",
+			xml: `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://example.com/</loc><lastmod>2024-01-01</lastmod></url>
+  <url><loc>
+    https://example.com/a?x=1&amp;y=2
+  </loc></url>
+</urlset>`,
+			want: []string{"https://example.com/", "https://example.com/a?x=1&y=2"},
+		},
+		{
+			name: "index",
+			xml: `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>https://example.com/s1.xml</loc></sitemap>
+  <sitemap><loc>https://example.com/s2.xml.gz</loc></sitemap>
+</sitemapindex>`,
+			want: []string{"index https://example.com/s1.xml", "index https://example.com/s2.xml.gz"},
+		},
+		{
+			name: "no namespace",
+			xml:  `<urlset><url><loc>https://example.com/</loc></url></urlset>`,
+			want: []string{"https://example.com/"},
+		},
+		{
+			name: "image extension",
+			xml: `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"
+  xmlns:image="http://www.google.com/schemas/sitemap-image/1.1">
+  <url>
+    <loc>https://example.com/page</loc>
+    <image:image><image:loc>https://example.com/photo.jpg</image:loc></image:image>
+  </url>
+</urlset>`,
+			want: []string{"https://example.com/page"},
+		},
+		{
+			name: "loc outside url",
+			xml: `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <loc>https://example.com/stray</loc>
+  <url><loc>https://example.com/</loc></url>
+  <loc>https://example.com/after</loc>
+</urlset>`,
+			want: []string{"https://example.com/"},
+		},
+		{
+			name: "empty loc",
+			xml:  `<urlset><url><loc> </loc></url><url><loc>https://example.com/</loc></url></urlset>`,
+			want: []string{"https://example.com/"},
+		},
+		{
+			name: "stopped",
+			xml: `<urlset>
+  <url><loc>https://example.com/1</loc></url>
+  <url><loc>https://example.com/2</loc></url>
+  <url><loc>https://example.com/3</loc></url>
+</urlset>`,
+			limit: 2,
+			want:  []string{"https://example.com/1", "https://example.com/2"},
+		},
+		{
+			name: "malformed",
+			xml:  `<urlset><url><loc>https://example.com/</loc></url>`,
+			want: []string{"https://example.com/"},
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		err := parseSitemap(strings.NewReader(tt.xml), func(loc string, index bool) bool {
+			if index {
+				loc = "index " + loc
+			}
+			got = append(got, loc)
+			return tt.limit == 0 || len(got) < tt.limit
+		})
+		if (err != nil) != tt.err {
+			t.Errorf("%s: parseSitemap error = %v, want error %v", tt.name, err, tt.err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseSitemap visited %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSearchSitemaps(t *testing.T) {
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Sitemap: %s/index.xml\n", server.URL)
+	})
+	mux.HandleFunc("/index.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<sitemapindex><sitemap><loc>%s/pages.xml</loc></sitemap></sitemapindex>`, server.URL)
+	})
+	mux.HandleFunc("/pages.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<urlset>`)
+		for _, page := range []string{"/", "/a", "/b", "/c", "/other"} {
+			fmt.Fprintf(w, `<url><loc>%s%s</loc></url>`, server.URL, page)
+		}
+		fmt.Fprint(w, `</urlset>`)
+	})
+	for _, page := range []string{"/a", "/b", "/c"} {
+		page := page
+		mux.HandleFunc(page, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `<p>needle %s</p><a href="/unlinked">more</a>`, page)
+		})
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte("<p>home</p>"))
+		case "/other":
+			w.Write([]byte("<p>nothing here</p>"))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	s := New(Options{MaxRequests: 2, Sitemaps: SitemapOptions{Enabled: true}})
+	results, err := s.Search(context.Background(), []string{"needle"}, []string{server.URL, server.URL + "/other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Index < results[j].Index })
+
+	// The pages in the sitemaps are grouped under the site that
+	// listed them, each searched once, and their links not followed.
+	site := results[0]
+	if site.Outcome != OutcomeOK || !site.Found {
+		t.Errorf("site result = %+v, want found", site)
+	}
+	if site.Pages != 5 || site.Count != 3 {
+		t.Errorf("site searched %d pages with %d matches, want 5 pages with 3 matches", site.Pages, site.Count)
+	}
+	matched := append([]string(nil), site.MatchedPages...)
+	sort.Strings(matched)
+	want := []string{server.URL + "/a", server.URL + "/b", server.URL + "/c"}
+	if !reflect.DeepEqual(matched, want) {
+		t.Errorf("matched pages %q, want %q", matched, want)
+	}
+
+	other := results[1]
+	if other.Outcome != OutcomeOK || other.Pages != 5 {
+		t.Errorf("other result = %+v, want 5 pages", other)
+	}
+}
+
+func TestSearchSitemapsCancelled(t *testing.T) {
+	var server *httptest.Server
+	started := make(chan struct{}, 10)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<urlset>`)
+		for i := 0; i < 10; i++ {
+			fmt.Fprintf(w, `<url><loc>%s/slow/%d</loc></url>`, server.URL, i)
+		}
+		fmt.Fprint(w, `</urlset>`)
+	})
+	mux.HandleFunc("/slow/", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-r.Context().Done()
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<p>home</p>"))
+	})
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	// Cancel the search once the workers are searching sitemap pages;
+	// the site is still reported, as cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	s := New(Options{MaxRequests: 2, Sitemaps: SitemapOptions{Enabled: true}})
+	results, err := s.Search(ctx, []string{"needle"}, []string{server.URL})
+	if err != context.Canceled {
+		t.Errorf("Search error = %v, want %v", err, context.Canceled)
+	}
+	if len(results) != 1 || results[0].Outcome != OutcomeCancelled {
+		t.Fatalf("results = %+v, want a single cancelled result", results)
+	}
+}
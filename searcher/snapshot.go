@@ -0,0 +1,366 @@
+package searcher
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/timehop/golog/log"
+)
+
+// snapshotManifest is the name of the file listing the sites in a snapshot.
+const snapshotManifest = "manifest.ndjson"
+
+// Snapshot is a directory of fetched pages, saved by one run so that
+// later runs can search them without network access.
+//
+// The manifest.ndjson file lists each site as a line of JSON, holding
+// the outcome of fetching it and the pages saved for it. The pages
+// directory holds the text extracted from each page and, optionally,
+// its HTML, in files named for the hash of the page's URL.
+type Snapshot struct {
+	dir string
+
+	// html saves the HTML of each page as well as its text.
+	html bool
+
+	// manifest is the manifest being written, if the snapshot
+	// is being saved.
+	mu       sync.Mutex
+	manifest *os.File
+
+	// sites maps the key of each site to the site, if the snapshot is
+	// being searched, and order lists the sites in the order saved.
+	sites map[string]*snapshotSite
+	order []*snapshotSite
+}
+
+// snapshotSite is the manifest entry for a site.
+type snapshotSite struct {
+	Site       string     `json:"site"`
+	Columns    []Column   `json:"columns,omitempty"`
+	Outcome    Outcome    `json:"outcome"`
+	StatusCode int        `json:"status_code,omitempty"`
+	Normalized string     `json:"normalized_url,omitempty"`
+	URL        string     `json:"final_url,omitempty"`
+	Redirects  []Redirect `json:"redirects,omitempty"`
+	Attempts   int        `json:"attempts,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	ErrorKind  ErrorKind  `json:"error_kind,omitempty"`
+
+	// Crawled records whether the site was crawled or its sitemaps
+	// were searched, even if no other pages were found.
+	Crawled bool           `json:"crawled,omitempty"`
+	Pages   []snapshotPage `json:"pages,omitempty"`
+}
+
+// snapshotPage is a page saved for a site. The first page is the
+// site's page, and any others were crawled or listed in its sitemaps.
+type snapshotPage struct {
+	URL       string `json:"url"`
+	Charset   string `json:"charset,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+
+	// Text and HTML are the paths of the files holding the page's
+	// text and HTML, relative to the snapshot directory.
+	Text string `json:"text"`
+	HTML string `json:"html,omitempty"`
+}
+
+// CreateSnapshot takes the path of a directory, creating it if needed,
+// and returns a snapshot saving pages to it. If html is set, each
+// page's HTML is saved as well as its text, so that the snapshot can
+// be searched by any Target, Regions or Selector. The directory must
+// not already contain a snapshot. The snapshot must be closed once
+// every page has been saved.
+func CreateSnapshot(dir string, html bool) (*Snapshot, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "pages"), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, snapshotManifest), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("%s already contains a snapshot", dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{dir: dir, html: html, manifest: f}, nil
+}
+
+// OpenSnapshot takes the path of a directory containing a snapshot
+// and returns the snapshot, to be searched.
+func OpenSnapshot(dir string) (*Snapshot, error) {
+	f, err := os.Open(filepath.Join(dir, snapshotManifest))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sn := &Snapshot{dir: dir, sites: map[string]*snapshotSite{}}
+	r := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(b)) > 0 {
+			site := &snapshotSite{}
+			if err := json.Unmarshal(b, site); err != nil {
+				return nil, fmt.Errorf("invalid snapshot manifest at line %d: %v", line, err)
+			}
+			sn.sites[siteKey(site.Site)] = site
+			sn.order = append(sn.order, site)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sn, nil
+}
+
+// Close finishes saving the snapshot.
+func (sn *Snapshot) Close() error {
+	if sn.manifest == nil {
+		return nil
+	}
+	return sn.manifest.Close()
+}
+
+// Records returns a record for each site in a snapshot being searched,
+// in the order they were saved, carrying the columns they were saved with.
+func (sn *Snapshot) Records() []Record {
+	var records []Record
+	for i, site := range sn.order {
+		records = append(records, Record{URL: site.Site, Columns: site.Columns, Line: i + 1})
+	}
+	return records
+}
+
+// siteKey returns the key used to look up a site: its normalized URL
+// without the scheme, or the site itself if it does not normalize.
+func siteKey(site string) string {
+	u, _, err := NormalizeURL(site)
+	if err != nil {
+		return strings.TrimSpace(site)
+	}
+	return strings.TrimPrefix(u.String(), u.Scheme+":")
+}
+
+// savePage saves a page's HTML, decoded to UTF-8, along with the text
+// extracted from it as it would be searched by default. If l is set,
+// the targets of the page's links are added to it.
+func (sn *Snapshot) savePage(url string, html []byte, l *links) (snapshotPage, error) {
+	sum := sha256.Sum256([]byte(url))
+	name := hex.EncodeToString(sum[:16])
+	page := snapshotPage{URL: url, Text: path.Join("pages", name+".txt")}
+
+	err := sn.writeFile(page.Text, func(w *bufio.Writer) error {
+		_, err := extractText(bytes.NewReader(html), &textFile{w: w}, scope{target: TargetText, regions: RegionBody.bit()}, l, false)
+		return err
+	})
+	if err != nil {
+		return page, err
+	}
+
+	if sn.html {
+		page.HTML = path.Join("pages", name+".html")
+		err := sn.writeFile(page.HTML, func(w *bufio.Writer) error {
+			_, err := w.Write(html)
+			return err
+		})
+		if err != nil {
+			return page, err
+		}
+	}
+	return page, nil
+}
+
+// writeFile writes a file in the snapshot, given its path relative to
+// the snapshot directory, by calling write. The file is written to a
+// temporary file and renamed into place, as sites that lead to the
+// same page may save it at the same time.
+func (sn *Snapshot) writeFile(name string, write func(w *bufio.Writer) error) error {
+	dest := filepath.Join(sn.dir, filepath.FromSlash(name))
+	f, err := os.CreateTemp(filepath.Dir(dest), filepath.Base(dest)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// textFile is a textWriter writing text to a file, ignoring regions.
+type textFile struct {
+	w *bufio.Writer
+}
+
+// write writes some text to the file.
+func (t *textFile) write(text string) {
+	t.w.WriteString(text)
+}
+
+// mark does nothing, as regions are not saved.
+func (t *textFile) mark(region Region) {}
+
+// done reports false, as the whole page is saved.
+func (t *textFile) done() bool {
+	return false
+}
+
+// addSite adds a site's result, and the pages saved for it,
+// to the manifest of the snapshot being saved.
+func (sn *Snapshot) addSite(result Result) error {
+	site := snapshotSite{
+		Site:       result.Site,
+		Columns:    result.Columns,
+		Outcome:    result.Outcome,
+		StatusCode: result.StatusCode,
+		Normalized: result.Normalized,
+		URL:        result.URL,
+		Redirects:  result.Redirects,
+		Attempts:   result.Attempts,
+		Crawled:    result.Pages > 0,
+		Pages:      result.saved,
+	}
+	if result.Err != nil {
+		site.Error = result.Err.Error()
+		var e *Error
+		if errors.As(result.Err, &e) {
+			site.Error = e.Err.Error()
+		}
+		site.ErrorKind = KindOf(result.Err)
+	}
+
+	line, err := json.Marshal(site)
+	if err != nil {
+		return err
+	}
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	_, err = sn.manifest.Write(append(line, '\n'))
+	return err
+}
+
+// err returns the error recorded when the site was saved, if any.
+func (site *snapshotSite) err() error {
+	switch {
+	case site.Error == "":
+		return nil
+	case site.ErrorKind == "":
+		return errors.New(site.Error)
+	}
+	return &Error{Kind: site.ErrorKind, Err: errors.New(site.Error)}
+}
+
+// searchSnapshot searches the pages saved for a site in the snapshot,
+// as searchSite would if they were fetched.
+func (s *Searcher) searchSnapshot(q *query, sc scope, site string) Result {
+	result := Result{Site: site}
+
+	saved, ok := s.opts.Snapshot.sites[siteKey(site)]
+	if !ok {
+		result.Err = classifyError(ErrorOther, errors.New("not in the snapshot"))
+		return result
+	}
+	result.Normalized = saved.Normalized
+	result.URL = saved.URL
+	result.StatusCode = saved.StatusCode
+	result.Redirects = saved.Redirects
+	result.Attempts = saved.Attempts
+	result.Err = saved.err()
+	if saved.Outcome == OutcomeSkippedRobots {
+		result.Outcome = OutcomeSkippedRobots
+	}
+
+	// Error pages are only searched if asked to, as when fetched.
+	if KindOf(result.Err) == ErrorHTTPStatus && !s.opts.SearchErrorPages {
+		return result
+	}
+
+	// Search the site's page, and merge in any other pages.
+	for i, page := range saved.Pages {
+		if i == 0 {
+			if err := s.searchSaved(q, sc, page, &result); err != nil {
+				result.Err = err
+				return result
+			}
+			if saved.Crawled {
+				firstPage(&result)
+			}
+			continue
+		}
+
+		pr := Result{URL: page.URL}
+		if err := s.searchSaved(q, sc, page, &pr); err != nil {
+			log.Debug("go-search", fmt.Sprintf("Skipping %s in the snapshot for %s.", page.URL, site), "error", err)
+			continue
+		}
+		mergePage(q, s.opts.Snippets, &result, pr)
+	}
+
+	return result
+}
+
+// searchSaved searches a page saved in the snapshot, recording the
+// outcome on the result. Pages saved with their HTML are searched as
+// if fetched; otherwise the saved text is searched as is, which is
+// only possible for the default scope.
+func (s *Searcher) searchSaved(q *query, sc scope, page snapshotPage, result *Result) error {
+	result.Charset = page.Charset
+	result.Truncated = page.Truncated
+
+	file := page.HTML
+	if file == "" {
+		if sc.target != TargetText || sc.report {
+			return classifyError(ErrorOther, errors.New("the snapshot has no HTML for the page, so it can only be searched with the default target and regions"))
+		}
+		file = page.Text
+	}
+	f, err := os.Open(filepath.Join(s.opts.Snapshot.dir, filepath.FromSlash(file)))
+	if err != nil {
+		return classifyError(ErrorOther, err)
+	}
+	defer f.Close()
+
+	st := newStream(q, s.opts.Snippets, s.opts.SnippetWindow, sc.report)
+	var stopped bool
+	if page.HTML != "" {
+		stopped, err = extractText(f, st, sc, nil, !s.opts.CountAll)
+	} else {
+		stopped, err = copyRaw(f, st, !s.opts.CountAll)
+	}
+	if err != nil {
+		return classifyError(ErrorBody, err)
+	}
+	result.Searched = true
+	result.StoppedEarly = stopped
+
+	st.finish(result)
+	return nil
+}
@@ -0,0 +1,82 @@
+package searcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSnapshotSavePageConcurrently(t *testing.T) {
+	sn, err := CreateSnapshot(t.TempDir(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sn.Close()
+
+	// Several sites redirecting to the same page save it at once,
+	// each having fetched a different version of it.
+	versions := make([]string, 8)
+	for i := range versions {
+		versions[i] = strings.Repeat(fmt.Sprintf("version %d ", i), 20000*(i+1))
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var page snapshotPage
+	for _, text := range versions {
+		wg.Add(1)
+		go func(text string) {
+			defer wg.Done()
+			p, err := sn.savePage("https://example.com/", []byte("<p>"+text+"</p>"), nil)
+			if err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			page = p
+			mu.Unlock()
+		}(text)
+	}
+	wg.Wait()
+
+	html, err := os.ReadFile(filepath.Join(sn.dir, page.HTML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := os.ReadFile(filepath.Join(sn.dir, page.Text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, v := range versions {
+		found = found || string(html) == "<p>"+v+"</p>"
+	}
+	if !found {
+		t.Errorf("saved HTML of %d bytes is not any one version of the page", len(html))
+	}
+	found = false
+	for _, v := range versions {
+		found = found || strings.TrimSpace(string(text)) == strings.TrimSpace(v)
+	}
+	if !found {
+		t.Errorf("saved text of %d bytes is not any one version of the page", len(text))
+	}
+
+	leftover, _ := filepath.Glob(filepath.Join(sn.dir, "pages", "*.tmp"))
+	if len(leftover) > 0 {
+		t.Errorf("temporary files left behind: %v", leftover)
+	}
+}
+
+func TestCreateSnapshotRefusesExisting(t *testing.T) {
+	dir := t.TempDir()
+	sn, err := CreateSnapshot(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sn.Close()
+	if _, err := CreateSnapshot(dir, false); err == nil {
+		t.Error("CreateSnapshot succeeded for a directory already containing a snapshot")
+	}
+}
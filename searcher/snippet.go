@@ -0,0 +1,61 @@
+package searcher
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultSnippetWindow is the default number of bytes of
+// surrounding text included on each side of a match in a snippet.
+const DefaultSnippetWindow = 40
+
+// Snippet is a piece of the page text surrounding a match.
+type Snippet struct {
+	// Term is the leaf term of the query that matched.
+	Term string `json:"term"`
+
+	// Text is the matched text with up to the configured window of
+	// surrounding text on each side. Runs of whitespace are collapsed
+	// to a single space.
+	Text string `json:"text"`
+
+	// Offset is the byte offset of the match in the extracted page text.
+	Offset int `json:"offset"`
+
+	// Line is the 1-based line number of the match in the extracted page text.
+	Line int `json:"line"`
+
+	// Region is the region of the page the match is in, if the search
+	// was scoped to regions. See Options.Regions.
+	Region Region `json:"region,omitempty"`
+
+	// URL is the URL of the page the match is on, if the site was
+	// crawled or its sitemaps were searched. See Options.Crawl.
+	URL string `json:"url,omitempty"`
+}
+
+// snippetText takes the text containing a match and the match's start
+// and end byte offsets, and returns the match with up to window bytes
+// of text on either side, with runs of whitespace collapsed.
+func snippetText(text string, start, end, window int) string {
+
+	// Widen the match by the window on each side, moving
+	// inwards to the nearest rune boundary.
+	from := start - window
+	if from < 0 {
+		from = 0
+	}
+	for from < start && !utf8.RuneStart(text[from]) {
+		from++
+	}
+	to := end + window
+	if to > len(text) {
+		to = len(text)
+	}
+	for to > end && to < len(text) && !utf8.RuneStart(text[to]) {
+		to--
+	}
+
+	return strings.Join(strings.FieldsFunc(text[from:to], unicode.IsSpace), " ")
+}
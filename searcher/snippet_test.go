@@ -0,0 +1,96 @@
+package searcher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSnippetText(t *testing.T) {
+	tests := []struct {
+		text       string
+		start, end int
+		window     int
+		want       string
+	}{
+		{"the quick brown fox jumps", 10, 15, 4, "ick brown fox"},
+		{"the quick brown fox jumps", 0, 3, 4, "the qui"},
+		{"the quick brown fox jumps", 20, 25, 100, "the quick brown fox jumps"},
+		{"the quick brown fox jumps", 10, 15, 0, "brown"},
+
+		// Runs of whitespace are collapsed, and leading and trailing
+		// whitespace dropped.
+		{"a\n\n  brown\t\tfox  \n b", 5, 10, 5, "a brown fox"},
+
+		// The window doesn't split characters.
+		{"日本語のテキスト", 9, 12, 4, "語のテ"},
+		{"日本語のテキスト", 9, 12, 6, "本語のテキ"},
+		{"café au lait", 6, 8, 2, "au l"},
+		{"café au lait", 6, 8, 3, "é au la"},
+	}
+	for _, tt := range tests {
+		if got := snippetText(tt.text, tt.start, tt.end, tt.window); got != tt.want {
+			t.Errorf("snippetText(%q, %d, %d, %d) = %q, want %q", tt.text, tt.start, tt.end, tt.window, got, tt.want)
+		}
+	}
+}
+
+func TestStreamSnippets(t *testing.T) {
+	tests := []struct {
+		terms []string
+		text  string
+		max   int
+		want  []Snippet
+	}{
+		{
+			terms: []string{"fox"},
+			text:  "the quick\nbrown fox\n\njumps over the FOX",
+			max:   3,
+			want: []Snippet{
+				{Term: "fox", Text: "own fox ju", Offset: 16, Line: 2},
+				{Term: "fox", Text: "the FOX", Offset: 36, Line: 4},
+			},
+		},
+		{
+			// Snippets are in the order their matches appear, up to
+			// the maximum, whatever the term.
+			terms: []string{"b", "a"},
+			text:  "a\nb\na\nb",
+			max:   3,
+			want: []Snippet{
+				{Term: "a", Text: "a b a", Offset: 0, Line: 1},
+				{Term: "b", Text: "a b a b", Offset: 2, Line: 2},
+				{Term: "a", Text: "a b a b", Offset: 4, Line: 3},
+			},
+		},
+		{
+			// Offsets are in bytes of the original text, even where
+			// folding changes its length.
+			terms: []string{"fox"},
+			text:  "ﬀ\nSTRASSE\nＦＯＸ",
+			max:   1,
+			want: []Snippet{
+				{Term: "fox", Text: "SSE ＦＯＸ", Offset: 12, Line: 3},
+			},
+		},
+		{
+			terms: []string{"fox"},
+			text:  "fox",
+			max:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		q, err := parseQueries(tt.terms, ModeSubstring, newFolder(NormalizeNFKC, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := newStream(q, tt.max, 4, false)
+		s.write(tt.text)
+		var result Result
+		s.finish(&result)
+		if !reflect.DeepEqual(result.Snippets, tt.want) {
+			t.Errorf("snippets of %q in %q = %+v, want %+v", strings.Join(tt.terms, ", "), tt.text, result.Snippets, tt.want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package searcher
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// streamChunk is the amount of page text buffered before it is matched.
+const streamChunk = 16 << 10
+
+// maxRegexMatch is the length of the longest regular expression match
+// guaranteed to be found whole when it spans chunks of page text.
+const maxRegexMatch = 1 << 10
+
+// hit is a single occurrence of a leaf term in the page text.
+type hit struct {
+	term       string
+	start, end int
+	region     Region
+}
+
+// span marks the byte offset in the page text at which the
+// text of a region starts.
+type span struct {
+	start  int
+	region Region
+}
+
+// stream matches a query against page text as it is extracted. It
+// matches the text a chunk at a time, keeping only as much as is
+// needed to find matches spanning chunks and to build snippets, so
+// memory use is bounded whatever the size of the page.
+type stream struct {
+	q           *query
+	maxSnippets int
+	window      int
+
+	// buf holds the text not yet discarded, which starts at byte
+	// offset base in the page text. Every match starting before byte
+	// offset limit has been recorded.
+	buf   []byte
+	base  int
+	limit int
+
+	// overlap is the amount of text held back from each chunk so
+	// that matches starting in the chunk are whole, and have their
+	// snippet's trailing window.
+	overlap int
+
+	// line is the line number at byte offset lineOffset in the page text.
+	line, lineOffset int
+
+	// spans marks where the text of each region starts, back to the
+	// last span starting at or before base. Regions are only
+	// recorded on results if report is set.
+	spans  []span
+	report bool
+
+	// next is the byte offset in the page text at which each term's
+	// search resumes, after its last match.
+	next map[*matcher]int
+
+	found    map[*matcher]bool
+	count    int
+	snippets []Snippet
+	regions  []Region
+}
+
+// newStream returns a stream matching the query, recording up to
+// maxSnippets snippets with window bytes of text on either side,
+// and if report is set, the region of each match.
+func newStream(q *query, maxSnippets, window int, report bool) *stream {
+
+	// Folding can shrink text by up to a factor of about four (e.g.
+	// full-width letters), so allow for matches that much longer
+	// than the folded terms.
+	longest := 0
+	for _, m := range q.leaves {
+		n := 4 * len(m.folded)
+		if m.mode == ModeRegex {
+			n = maxRegexMatch
+		}
+		if n > longest {
+			longest = n
+		}
+	}
+
+	return &stream{
+		q:           q,
+		maxSnippets: maxSnippets,
+		window:      window,
+		overlap:     longest + window + utf8.UTFMax,
+		line:        1,
+		report:      report,
+		next:        map[*matcher]int{},
+		found:       map[*matcher]bool{},
+	}
+}
+
+// write appends page text to the stream, matching it once a full
+// chunk has been buffered.
+func (s *stream) write(text string) {
+	s.buf = append(s.buf, text...)
+	if len(s.buf) >= streamChunk+s.overlap {
+		s.scan(false)
+	}
+}
+
+// mark records that the text written next is in the given region.
+func (s *stream) mark(region Region) {
+	s.spans = append(s.spans, span{start: s.base + len(s.buf), region: region})
+}
+
+// regionAt returns the region of the text at a byte offset
+// in the page text.
+func (s *stream) regionAt(offset int) Region {
+	i := sort.Search(len(s.spans), func(i int) bool {
+		return s.spans[i].start > offset
+	})
+	if i == 0 {
+		return ""
+	}
+	return s.spans[i-1].region
+}
+
+// done reports whether every term has been found, so that reading
+// more text would only add to the count and to the snippets. Until
+// maxSnippets are recorded, each match has a snippet, so every term
+// already has one.
+func (s *stream) done() bool {
+	return len(s.found) == len(s.q.leaves)
+}
+
+// finish matches any remaining text and records the outcome on the result.
+func (s *stream) finish(result *Result) {
+	s.scan(true)
+
+	result.Found = s.q.root.eval(s.found)
+	result.Count = s.count
+	for _, m := range s.q.leaves {
+		if s.found[m] {
+			result.Matched = append(result.Matched, m.term)
+		}
+	}
+	result.Snippets = s.snippets
+	result.Regions = s.regions
+}
+
+// scan records the matches in the buffered text. Unless this is the
+// final scan, matches starting in the last overlap bytes are left for
+// the next scan, and the text no longer needed is discarded.
+func (s *stream) scan(final bool) {
+	end := len(s.buf)
+	if !final {
+		end -= s.overlap
+	}
+	text := string(s.buf)
+	p := newPage(text, s.q.folder)
+
+	// Find each term's matches from where its last search left off.
+	var hits []hit
+	for _, m := range s.q.leaves {
+		from := s.next[m]
+		if from < s.limit {
+			from = s.limit
+		}
+		for _, loc := range m.find(p, from-s.base) {
+			if loc[0] >= end {
+				break
+			}
+			hits = append(hits, hit{term: m.term, start: loc[0], end: loc[1], region: s.regionAt(s.base + loc[0])})
+			s.found[m] = true
+			s.next[m] = s.base + loc[1]
+			if loc[0] == loc[1] {
+				s.next[m]++
+			}
+		}
+	}
+	s.count += len(hits)
+
+	// Record the regions matched in and the snippets for the first
+	// matches in the order they appear, counting lines incrementally.
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].start < hits[j].start
+	})
+	if s.report {
+		for _, h := range hits {
+			s.addRegion(h.region)
+		}
+	}
+	for _, h := range hits {
+		if len(s.snippets) >= s.maxSnippets {
+			break
+		}
+		s.line += strings.Count(text[s.lineOffset-s.base:h.start], "\n")
+		s.lineOffset = s.base + h.start
+
+		snippet := Snippet{
+			Term:   h.term,
+			Text:   snippetText(text, h.start, h.end, s.window),
+			Offset: s.base + h.start,
+			Line:   s.line,
+		}
+		if s.report {
+			snippet.Region = h.region
+		}
+		s.snippets = append(s.snippets, snippet)
+	}
+
+	s.limit = s.base + end
+	if final {
+		return
+	}
+
+	// Discard the text before the leading window of the next match,
+	// cutting at a character boundary so the rest folds the same way.
+	keep := s.boundary(end - s.window)
+	if s.lineOffset < s.base+keep {
+		s.line += strings.Count(text[s.lineOffset-s.base:keep], "\n")
+		s.lineOffset = s.base + keep
+	}
+	s.buf = append(s.buf[:0], s.buf[keep:]...)
+	s.base += keep
+
+	// Drop the spans before the one containing the new base.
+	i := 0
+	for i+1 < len(s.spans) && s.spans[i+1].start <= s.base {
+		i++
+	}
+	s.spans = append(s.spans[:0], s.spans[i:]...)
+}
+
+// addRegion adds a region to the regions matched in, if it is not
+// there already.
+func (s *stream) addRegion(region Region) {
+	for _, r := range s.regions {
+		if r == region {
+			return
+		}
+	}
+	s.regions = append(s.regions, region)
+}
+
+// boundary takes a byte offset in the buffer and returns the nearest
+// offset at or before it which starts a rune and, if possible, a
+// normalization segment.
+func (s *stream) boundary(i int) int {
+	if i <= 0 {
+		return 0
+	}
+	for j := i; j > 0 && i-j < 64; j-- {
+		if utf8.RuneStart(s.buf[j]) && s.q.folder.form.Properties(s.buf[j:]).BoundaryBefore() {
+			return j
+		}
+	}
+	for i > 0 && !utf8.RuneStart(s.buf[i]) {
+		i--
+	}
+	return i
+}
@@ -0,0 +1,174 @@
+package searcher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// streamText returns several chunks of page text holding n occurrences
+// of the given variants of a term, placed every 50 bytes so that some
+// span each chunk boundary, and split at the start of each region.
+func streamText(variants []string) (segments []string, regions []Region, n int) {
+	filler := []byte(strings.Repeat("lorem ipsum dolor sit amet consectetur\n", 3*streamChunk/39))
+	for i := 10; i+30 < len(filler); i += 50 {
+		v := " " + variants[n%len(variants)] + " "
+		copy(filler[i:], v)
+		n++
+	}
+
+	// Alternate regions every 1000 bytes or so, at a space.
+	text := string(filler)
+	for len(text) > 0 {
+		i := 1000
+		if i >= len(text) {
+			i = len(text)
+		} else {
+			i += strings.IndexByte(text[i:], ' ')
+		}
+		segments = append(segments, text[:i])
+		regions = append(regions, []Region{RegionBody, RegionLinks, RegionHeadings}[len(regions)%3])
+		text = text[i:]
+	}
+	return segments, regions, n
+}
+
+func TestStreamMatchesSpanningChunks(t *testing.T) {
+	tests := []struct {
+		expr     string
+		mode     Mode
+		accents  bool
+		variants []string
+	}{
+		{expr: "needle", variants: []string{"needle", "NEEDLE", "ｎｅｅｄｌｅ", "NeEdLe"}},
+		{expr: "needle", accents: true, variants: []string{"needle", "nëédlé", "ＮＥＥＤＬＥ"}},
+		{expr: "needle", mode: ModeWholeWord, variants: []string{"needle", "Needle"}},
+		{expr: "ne+dle", mode: ModeRegex, variants: []string{"nedle", "neeeeeeeeeeedle", "NEEDLE"}},
+		{expr: "two words", variants: []string{"two words", "TWO WORDS", "ｔｗｏ ｗｏｒｄｓ"}},
+	}
+
+	for _, tt := range tests {
+		q, err := parseQueries([]string{tt.expr}, tt.mode, newFolder(NormalizeNFKC, tt.accents))
+		if err != nil {
+			t.Fatal(err)
+		}
+		segments, regions, n := streamText(tt.variants)
+
+		// The reference matches the whole text in a single scan.
+		var want Result
+		ref := newStream(q, 1<<20, 20, true)
+		for i, seg := range segments {
+			ref.mark(regions[i])
+			ref.buf = append(ref.buf, seg...)
+		}
+		ref.finish(&want)
+		if want.Count != n || !want.Found {
+			t.Fatalf("%q: found %d matches, want %d", tt.expr, want.Count, n)
+		}
+
+		// Writing the text in pieces of any size gives the same result.
+		for _, size := range []int{1, 7, 64, 1000, 5000, streamChunk} {
+			var got Result
+			s := newStream(q, 1<<20, 20, true)
+			for i, seg := range segments {
+				s.mark(regions[i])
+				for len(seg) > 0 {
+					k := size
+					if k > len(seg) {
+						k = len(seg)
+					}
+					s.write(seg[:k])
+					seg = seg[k:]
+				}
+			}
+			if s.base == 0 {
+				t.Fatalf("%q: text was matched in a single chunk", tt.expr)
+			}
+			s.finish(&got)
+
+			if got.Count != want.Count || got.Found != want.Found || !reflect.DeepEqual(got.Matched, want.Matched) {
+				t.Errorf("%q in writes of %d bytes: found %d matches of %v, want %d of %v", tt.expr, size, got.Count, got.Matched, want.Count, want.Matched)
+			}
+			if !reflect.DeepEqual(got.Regions, want.Regions) {
+				t.Errorf("%q in writes of %d bytes: regions %v, want %v", tt.expr, size, got.Regions, want.Regions)
+			}
+			if len(got.Snippets) != len(want.Snippets) {
+				t.Errorf("%q in writes of %d bytes: %d snippets, want %d", tt.expr, size, len(got.Snippets), len(want.Snippets))
+				continue
+			}
+			for i := range got.Snippets {
+				if got.Snippets[i] != want.Snippets[i] {
+					t.Errorf("%q in writes of %d bytes: snippet %d is %+v, want %+v", tt.expr, size, i, got.Snippets[i], want.Snippets[i])
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestStreamSnippetLimit(t *testing.T) {
+	q, err := parseQueries([]string{"needle"}, ModeSubstring, newFolder(NormalizeNFKC, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments, _, n := streamText([]string{"needle"})
+	s := newStream(q, 3, 10, false)
+	for _, seg := range segments {
+		s.write(seg)
+	}
+	if !s.done() {
+		t.Errorf("stream not done after finding every term and snippet")
+	}
+
+	var result Result
+	s.finish(&result)
+	if result.Count != n || len(result.Snippets) != 3 || result.Regions != nil {
+		t.Errorf("found %d matches with %d snippets in %v, want %d with 3 snippets and no regions", result.Count, len(result.Snippets), result.Regions, n)
+	}
+	text := strings.Join(segments, "")
+	for i, snippet := range result.Snippets {
+		if !strings.HasPrefix(text[snippet.Offset:], "needle") {
+			t.Errorf("snippet %d is at offset %d, where the text is %q", i, snippet.Offset, text[snippet.Offset:snippet.Offset+10])
+		}
+		if line := strings.Count(text[:snippet.Offset], "\n") + 1; snippet.Line != line {
+			t.Errorf("snippet %d is on line %d, want %d", i, snippet.Line, line)
+		}
+	}
+}
+
+func TestStreamDone(t *testing.T) {
+	q, err := parseQueries([]string{"alpha AND beta"}, ModeSubstring, newFolder(NormalizeNFKC, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filler := strings.Repeat("lorem ipsum dolor sit amet\n", streamChunk/10)
+
+	tests := []struct {
+		text        string
+		maxSnippets int
+		want        bool
+	}{
+		// Each term occurring once is enough, whatever the number of
+		// snippets wanted.
+		{"alpha beta " + filler, 3, true},
+		{"alpha beta " + filler, 0, true},
+		{"alpha " + filler, 3, false},
+		{filler + "alpha beta", 3, false},
+	}
+	for i, tt := range tests {
+		s := newStream(q, tt.maxSnippets, 10, false)
+		s.write(tt.text)
+		if got := s.done(); got != tt.want {
+			t.Errorf("%d: done() = %v, want %v", i, got, tt.want)
+		}
+		var result Result
+		s.finish(&result)
+		want := tt.maxSnippets
+		if result.Count < want {
+			want = result.Count
+		}
+		if len(result.Snippets) != want {
+			t.Errorf("%d: %d snippets, want %d", i, len(result.Snippets), want)
+		}
+	}
+}
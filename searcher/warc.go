@@ -0,0 +1,616 @@
+package searcher
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/timehop/golog/log"
+)
+
+// maxWARCRecord caps the size of the response bodies written to a WARC
+// file. Larger bodies are written up to it and marked as truncated.
+const maxWARCRecord = 32 << 20
+
+// WARCWriter writes every request sent and response received to a
+// WARC (Web ARChive) file, so that a run's fetches can be archived and
+// audited, or searched again later with a WARCArchive. If the file's
+// name ends in .gz, each record is gzipped separately, as is usual for
+// .warc.gz files.
+//
+// A WARCWriter is safe for concurrent use. It must be closed once
+// every response body has been closed.
+type WARCWriter struct {
+	gzip bool
+
+	// infoID is the ID of the warcinfo record describing the file.
+	infoID string
+
+	// mu guards the file, so records are written whole.
+	mu   sync.Mutex
+	file *os.File
+}
+
+// CreateWARC takes the path of a WARC file, which must not already
+// exist, and returns a writer writing to it.
+func CreateWARC(path string) (*WARCWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("%s already exists", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	w := &WARCWriter{gzip: strings.HasSuffix(path, ".gz"), infoID: warcRecordID(), file: f}
+
+	info := "software: go-search\r\nformat: WARC File Format 1.0\r\n"
+	err = w.write([]warcField{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", w.infoID},
+		{"WARC-Date", warcDate(time.Now())},
+		{"WARC-Filename", path[strings.LastIndexAny(path, `/\`)+1:]},
+		{"Content-Type", "application/warc-fields"},
+	}, strings.NewReader(info), int64(len(info)))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Close closes the WARC file.
+func (w *WARCWriter) Close() error {
+	return w.file.Close()
+}
+
+// warcField is a named field in the header of a WARC record.
+type warcField struct {
+	name, value string
+}
+
+// warcRecordID returns a new, unique WARC record ID.
+func warcRecordID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// warcDate formats a time as used in the WARC-Date field.
+func warcDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// warcDigest formats a SHA-1 digest as used in the WARC-Block-Digest
+// and WARC-Payload-Digest fields.
+func warcDigest(h hash.Hash) string {
+	return "sha1:" + base32.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// write writes a record to the file, given its header fields and its
+// block of length bytes, gzipping it if the file is gzipped.
+func (w *WARCWriter) write(fields []warcField, block io.Reader, length int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var out io.Writer = w.file
+	var gz *gzip.Writer
+	if w.gzip {
+		gz = gzip.NewWriter(w.file)
+		out = gz
+	}
+	bw := bufio.NewWriter(out)
+
+	bw.WriteString("WARC/1.0\r\n")
+	for _, f := range fields {
+		fmt.Fprintf(bw, "%s: %s\r\n", f.name, f.value)
+	}
+	fmt.Fprintf(bw, "Content-Length: %d\r\n\r\n", length)
+	if _, err := io.CopyN(bw, block, length); err != nil {
+		return err
+	}
+	bw.WriteString("\r\n\r\n")
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
+
+// transport returns an http.RoundTripper passing requests on to next
+// and writing each request and its response to the WARC file.
+func (w *WARCWriter) transport(next http.RoundTripper) http.RoundTripper {
+	return &warcTransport{warc: w, next: next}
+}
+
+// warcTransport is an http.RoundTripper recording to a WARCWriter.
+type warcTransport struct {
+	warc *WARCWriter
+	next http.RoundTripper
+}
+
+// RoundTrip passes a request on, and returns the response with a body
+// that writes the request and response to the WARC file once it has
+// been read or closed.
+func (t *warcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	date := time.Now()
+	response, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp("", "go-search-*.warc.tmp")
+	if err != nil {
+		log.Debug("go-search", fmt.Sprintf("Could not record the response for %s to the WARC file.", req.URL), "error", err)
+		return response, nil
+	}
+
+	// The response's status line and headers are written ahead of
+	// its body. The body is recorded as received from the client,
+	// so it is decompressed and dechunked, and the Content-Encoding
+	// and Transfer-Encoding headers are already removed. Responses
+	// served from the cache are recorded as the server sent them.
+	var head bytes.Buffer
+	fmt.Fprintf(&head, "%s %s\r\n", response.Proto, response.Status)
+	header := response.Header.Clone()
+	header.Del(cacheStatusHeader)
+	header.Write(&head)
+	head.WriteString("\r\n")
+
+	b := &warcBody{
+		body:    response.Body,
+		warc:    t.warc,
+		req:     req,
+		date:    date,
+		head:    head.Bytes(),
+		file:    f,
+		block:   sha1.New(),
+		payload: sha1.New(),
+	}
+	b.block.Write(b.head)
+	response.Body = b
+	return response, nil
+}
+
+// warcBody is a response body that writes what is read from it to a
+// temporary file, and writes the request and response to the WARC file
+// once the body has been read to the end. If the body is closed first,
+// the part of it read is written, marked as truncated.
+type warcBody struct {
+	body io.ReadCloser
+	warc *WARCWriter
+	req  *http.Request
+	date time.Time
+
+	// head is the response's status line and headers, and file
+	// holds its body. block and payload hash the whole response
+	// and its body, as the WARC record's digests.
+	head           []byte
+	file           *os.File
+	block, payload hash.Hash
+
+	// n is the number of bytes of the body read, truncated records
+	// why the body was not read in full, if it was not, and done
+	// reports whether the records have been written.
+	n         int64
+	truncated string
+	done      bool
+}
+
+// Read reads from the body, recording what is read.
+func (b *warcBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 && !b.done {
+		b.record(p[:n])
+	}
+	if err == io.EOF && !b.done {
+		b.finish()
+	} else if err != nil && !b.done {
+		b.truncated = "disconnect"
+		b.finish()
+	}
+	return n, err
+}
+
+// record records part of the body, truncating the body once it
+// reaches maxWARCRecord bytes.
+func (b *warcBody) record(p []byte) {
+	if b.n+int64(len(p)) > maxWARCRecord {
+		p = p[:maxWARCRecord-b.n]
+		b.truncated = "length"
+	}
+	if _, err := b.file.Write(p); err != nil {
+		log.Debug("go-search", fmt.Sprintf("Could not record the response for %s to the WARC file.", b.req.URL), "error", err)
+		b.abort()
+		return
+	}
+	b.n += int64(len(p))
+	b.block.Write(p)
+	b.payload.Write(p)
+	if b.truncated != "" {
+		b.finish()
+	}
+}
+
+// abort discards the recorded body without writing any records.
+func (b *warcBody) abort() {
+	b.done = true
+	b.file.Close()
+	os.Remove(b.file.Name())
+}
+
+// finish writes the request and response records to the WARC file,
+// and discards the temporary file.
+func (b *warcBody) finish() {
+	defer b.abort()
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		log.Debug("go-search", fmt.Sprintf("Could not record the response for %s to the WARC file.", b.req.URL), "error", err)
+		return
+	}
+
+	// The request is reconstructed from the request passed to the
+	// transport, as the exact bytes sent are not available.
+	var request bytes.Buffer
+	fmt.Fprintf(&request, "%s %s HTTP/1.1\r\nHost: %s\r\n", b.req.Method, b.req.URL.RequestURI(), hostHeader(b.req))
+	b.req.Header.Write(&request)
+	request.WriteString("\r\n")
+
+	target := b.req.URL.String()
+	responseID := warcRecordID()
+	date := warcDate(b.date)
+
+	fields := []warcField{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", responseID},
+		{"WARC-Warcinfo-ID", b.warc.infoID},
+		{"WARC-Date", date},
+		{"WARC-Target-URI", target},
+		{"Content-Type", "application/http; msgtype=response"},
+		{"WARC-Block-Digest", warcDigest(b.block)},
+		{"WARC-Payload-Digest", warcDigest(b.payload)},
+	}
+	if b.truncated != "" {
+		fields = append(fields, warcField{"WARC-Truncated", b.truncated})
+	}
+	block := io.MultiReader(bytes.NewReader(b.head), b.file)
+	err := b.warc.write(fields, block, int64(len(b.head))+b.n)
+
+	if err == nil {
+		digest := sha1.Sum(request.Bytes())
+		err = b.warc.write([]warcField{
+			{"WARC-Type", "request"},
+			{"WARC-Record-ID", warcRecordID()},
+			{"WARC-Warcinfo-ID", b.warc.infoID},
+			{"WARC-Date", date},
+			{"WARC-Target-URI", target},
+			{"WARC-Concurrent-To", responseID},
+			{"Content-Type", "application/http; msgtype=request"},
+			{"WARC-Block-Digest", "sha1:" + base32.StdEncoding.EncodeToString(digest[:])},
+		}, &request, int64(request.Len()))
+	}
+	if err != nil {
+		log.Error("go-search", fmt.Sprintf("Could not write the response for %s to the WARC file.", target), "error", err)
+	}
+}
+
+// Close writes the part of the body read to the WARC file, if it was
+// not read to the end, and closes it.
+func (b *warcBody) Close() error {
+	if !b.done {
+		b.truncated = "unspecified"
+		b.finish()
+	}
+	return b.body.Close()
+}
+
+// hostHeader returns the Host header sent with a request.
+func hostHeader(req *http.Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+	return req.URL.Host
+}
+
+// WARCArchive serves responses from WARC files, so that searches can
+// be run over pages archived by go-search or by other tools instead of
+// the network. Files may be uncompressed, or gzipped as a whole or
+// record by record.
+//
+// Only response records are used. If a URL has several responses, the
+// last is used, and responses with a 1xx or 304 status are ignored.
+type WARCArchive struct {
+	records map[string]warcLocation
+}
+
+// warcLocation is the location of a response record's block in a
+// WARC file.
+type warcLocation struct {
+	path string
+
+	// member is the offset in the file of the gzip member holding the
+	// record, or -1 if the file is not gzipped, and offset the offset
+	// of the record's block in the member or file.
+	member, offset int64
+	length         int64
+
+	// truncated reports whether the record holds only part of the
+	// response's body.
+	truncated bool
+}
+
+// OpenWARC takes the paths of one or more WARC files and returns an
+// archive serving the responses recorded in them. The files are read
+// once to index their response records.
+func OpenWARC(paths ...string) (*WARCArchive, error) {
+	a := &WARCArchive{records: map[string]warcLocation{}}
+	for _, path := range paths {
+		if err := a.index(path); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	log.Debug("go-search", fmt.Sprintf("Found %d responses in the WARC files.", len(a.records)))
+	return a, nil
+}
+
+// countingReader counts the bytes read from a bufio.Reader. It is an
+// io.ByteReader, so that a gzip.Reader reads no further from it than
+// the end of each member.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+// Read reads from the underlying reader.
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ReadByte reads a byte from the underlying reader.
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// index reads a WARC file, adding the location of each of its
+// response records to the archive.
+func (a *WARCArchive) index(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Gzipped files are detected by their magic number, and read a
+	// member at a time, so each record's member can be found again.
+	br := bufio.NewReader(f)
+	if magic, _ := br.Peek(2); len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return a.indexRecords(br, path, -1)
+	}
+
+	cr := &countingReader{r: br}
+	var gz gzip.Reader
+	for {
+		member := cr.n
+		if err := gz.Reset(cr); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		gz.Multistream(false)
+		if err := a.indexRecords(bufio.NewReader(&gz), path, member); err != nil {
+			return err
+		}
+	}
+}
+
+// indexRecords reads the records from r, which holds either a whole
+// uncompressed file or a gzip member at the given offset, adding the
+// location of each response record to the archive.
+func (a *WARCArchive) indexRecords(r *bufio.Reader, path string, member int64) error {
+	var offset int64
+	readLine := func() (string, error) {
+		line, err := r.ReadString('\n')
+		offset += int64(len(line))
+		return strings.TrimRight(line, "\r\n"), err
+	}
+
+	for {
+		// Skip any blank lines ending the previous record.
+		line, err := readLine()
+		for line == "" && err == nil {
+			line, err = readLine()
+		}
+		if err == io.EOF && line == "" {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "WARC/") {
+			return fmt.Errorf("invalid WARC record at offset %d: %q", offset-int64(len(line)), line)
+		}
+
+		fields := map[string]string{}
+		for {
+			line, err := readLine()
+			if err != nil {
+				return err
+			}
+			if line == "" {
+				break
+			}
+			if i := strings.IndexByte(line, ':'); i > 0 {
+				fields[strings.ToLower(strings.TrimSpace(line[:i]))] = strings.TrimSpace(line[i+1:])
+			}
+		}
+		length, err := strconv.ParseInt(fields["content-length"], 10, 64)
+		if err != nil || length < 0 {
+			return fmt.Errorf("invalid WARC record at offset %d: bad Content-Length", offset)
+		}
+
+		// Check the status of HTTP responses, reading the first line
+		// of the block, and skip the rest.
+		block := &io.LimitedReader{R: r, N: length}
+		if fields["warc-type"] == "response" && strings.HasPrefix(fields["content-type"], "application/http") {
+			status, _ := bufio.NewReaderSize(block, 256).ReadString('\n')
+			a.add(fields["warc-target-uri"], status, warcLocation{
+				path:      path,
+				member:    member,
+				offset:    offset,
+				length:    length,
+				truncated: fields["warc-truncated"] != "",
+			})
+		}
+		if _, err := io.Copy(io.Discard, block); err != nil {
+			return err
+		}
+		if block.N > 0 {
+			return io.ErrUnexpectedEOF
+		}
+		offset += length
+	}
+}
+
+// add adds the location of a response record to the archive, given
+// its target URI and the response's status line.
+func (a *WARCArchive) add(target, status string, loc warcLocation) {
+	target = strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+	u, err := url.Parse(target)
+	if err != nil {
+		return
+	}
+	parts := strings.Fields(status)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "HTTP/") {
+		return
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil || code < 200 || code == http.StatusNotModified {
+		return
+	}
+	a.records[u.String()] = loc
+}
+
+// transport returns an http.RoundTripper serving responses from
+// the archive instead of the network.
+func (a *WARCArchive) transport() http.RoundTripper {
+	return archiveTransport{archive: a}
+}
+
+// archiveTransport is an http.RoundTripper backed by a WARCArchive.
+type archiveTransport struct {
+	archive *WARCArchive
+}
+
+// RoundTrip serves a request with the response recorded for its URL,
+// or fails if there is none.
+func (t archiveTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	loc, ok := t.archive.records[req.URL.String()]
+	if !ok || req.Method != "GET" {
+		return nil, errors.New("not in the WARC archive")
+	}
+
+	f, err := os.Open(loc.path)
+	if err != nil {
+		return nil, err
+	}
+	block, err := loc.open(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	response, err := http.ReadResponse(bufio.NewReader(io.LimitReader(block, loc.length)), req)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("invalid response for %s in the WARC archive: %v", req.URL, err)
+	}
+
+	// Responses recorded as received on the wire may still be
+	// compressed, as most tools record them.
+	var body io.Reader = response.Body
+	if strings.EqualFold(response.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("invalid response for %s in the WARC archive: %v", req.URL, err)
+		}
+		body = gz
+		response.Header.Del("Content-Encoding")
+		response.Header.Del("Content-Length")
+		response.ContentLength = -1
+		response.Uncompressed = true
+	}
+
+	// The body of a truncated record ends early, before its
+	// Content-Length or the end of its compressed stream, so it is
+	// read to the end of the record instead.
+	if loc.truncated {
+		body = truncatedBody{body}
+		response.Header.Del("Content-Length")
+		response.ContentLength = -1
+	}
+	response.Body = readCloser{Reader: body, Closer: f}
+	return response, nil
+}
+
+// truncatedBody is the body of a truncated response record, which
+// ends before the length given by its headers.
+type truncatedBody struct {
+	r io.Reader
+}
+
+// Read reads from the body, ending it where the record ends.
+func (b truncatedBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// open returns a reader positioned at the start of the record's
+// block in f.
+func (loc warcLocation) open(f *os.File) (io.Reader, error) {
+	if loc.member < 0 {
+		if _, err := f.Seek(loc.offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+
+	if _, err := f.Seek(loc.member, io.SeekStart); err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	gz.Multistream(false)
+	if _, err := io.CopyN(io.Discard, gz, loc.offset); err != nil {
+		return nil, err
+	}
+	return gz, nil
+}
@@ -0,0 +1,370 @@
+package searcher
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// warcRecord is a record read back from a WARC file.
+type warcRecord struct {
+	fields map[string]string
+	block  []byte
+}
+
+// readWARCRecords reads every record from a WARC file, gzipped or not.
+func readWARCRecords(t *testing.T, path string) []warcRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gz
+	}
+	br := bufio.NewReader(r)
+
+	var records []warcRecord
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return records
+		}
+		if line != "WARC/1.0\r\n" {
+			t.Fatalf("record %d starts with %q", len(records), line)
+		}
+		rec := warcRecord{fields: map[string]string{}}
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if line == "" {
+				break
+			}
+			name, value, _ := strings.Cut(line, ": ")
+			rec.fields[name] = value
+		}
+		length, err := strconv.Atoi(rec.fields["Content-Length"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec.block = make([]byte, length)
+		if _, err := io.ReadFull(br, rec.block); err != nil {
+			t.Fatal(err)
+		}
+		var end [4]byte
+		if _, err := io.ReadFull(br, end[:]); err != nil || string(end[:]) != "\r\n\r\n" {
+			t.Fatalf("record %d ends with %q (%v)", len(records), end, err)
+		}
+		records = append(records, rec)
+	}
+}
+
+func sha1Digest(b []byte) string {
+	sum := sha1.Sum(b)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestWARCRoundTrip(t *testing.T) {
+	page := "<html><body><p>needle</p></body></html>"
+	big := strings.Repeat("0123456789", 10000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, page)
+		case "/gzipped":
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			io.WriteString(gz, page)
+			gz.Close()
+		case "/big":
+			w.Header().Set("Content-Length", strconv.Itoa(len(big)))
+			io.WriteString(w, big)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	for _, name := range []string{"run.warc", "run.warc.gz"} {
+		path := filepath.Join(t.TempDir(), name)
+		w, err := CreateWARC(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := CreateWARC(path); err == nil {
+			t.Errorf("%s: CreateWARC succeeded on an existing file", name)
+		}
+
+		// Record the responses, closing the big one before it is
+		// read to the end.
+		client := newClient(ClientOptions{WARC: w}, 0)
+		get := func(client *http.Client, path string, n int64) (*http.Response, string, error) {
+			response, err := client.Get(server.URL + path)
+			if err != nil {
+				return nil, "", err
+			}
+			defer response.Body.Close()
+			body, err := io.ReadAll(io.LimitReader(response.Body, n))
+			return response, string(body), err
+		}
+		for _, path := range []string{"/page", "/gzipped", "/missing"} {
+			if _, _, err := get(client, path, 1<<20); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, _, err := get(client, "/big", 100); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Check the records and their digests.
+		records := readWARCRecords(t, path)
+		if len(records) != 9 || records[0].fields["WARC-Type"] != "warcinfo" {
+			t.Fatalf("%s: got %d records, want a warcinfo record and 4 responses with their requests", name, len(records))
+		}
+		for _, rec := range records[1:] {
+			if rec.fields["WARC-Warcinfo-ID"] != records[0].fields["WARC-Record-ID"] {
+				t.Errorf("%s: record not linked to the warcinfo record", name)
+			}
+			if got := sha1Digest(rec.block); rec.fields["WARC-Block-Digest"] != got {
+				t.Errorf("%s: %s record for %s has block digest %s, want %s", name, rec.fields["WARC-Type"], rec.fields["WARC-Target-URI"], rec.fields["WARC-Block-Digest"], got)
+			}
+			if rec.fields["WARC-Type"] != "response" {
+				continue
+			}
+			_, payload, _ := bytes.Cut(rec.block, []byte("\r\n\r\n"))
+			if got := sha1Digest(payload); rec.fields["WARC-Payload-Digest"] != got {
+				t.Errorf("%s: response for %s has payload digest %s, want %s", name, rec.fields["WARC-Target-URI"], rec.fields["WARC-Payload-Digest"], got)
+			}
+			truncated := rec.fields["WARC-Target-URI"] == server.URL+"/big"
+			if (rec.fields["WARC-Truncated"] == "unspecified") != truncated {
+				t.Errorf("%s: response for %s has WARC-Truncated %q", name, rec.fields["WARC-Target-URI"], rec.fields["WARC-Truncated"])
+			}
+			if truncated && len(payload) >= len(big) {
+				t.Errorf("%s: response for %s recorded %d bytes after closing early", name, rec.fields["WARC-Target-URI"], len(payload))
+			}
+		}
+
+		// Replay the responses from the file, as the file and as a
+		// file gzipped whole.
+		paths := []string{path}
+		if !strings.HasSuffix(name, ".gz") {
+			whole := path + ".gz"
+			gzipFile(t, path, whole)
+			paths = append(paths, whole)
+		}
+		for _, path := range paths {
+			archive, err := OpenWARC(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := newClient(ClientOptions{Archive: archive}, 0)
+			for _, tt := range []struct {
+				path   string
+				status int
+				body   string
+			}{
+				{"/page", http.StatusOK, page},
+				{"/gzipped", http.StatusOK, page},
+				{"/missing", http.StatusNotFound, "404 page not found\n"},
+			} {
+				response, body, err := get(client, tt.path, 1<<20)
+				if err != nil {
+					t.Errorf("%s: replaying %s failed: %v", filepath.Base(path), tt.path, err)
+					continue
+				}
+				if response.StatusCode != tt.status || body != tt.body {
+					t.Errorf("%s: replayed %s as %d %q, want %d %q", filepath.Base(path), tt.path, response.StatusCode, body, tt.status, tt.body)
+				}
+			}
+
+			// The truncated response replays the part recorded.
+			response, body, err := get(client, "/big", 1<<20)
+			if err != nil {
+				t.Errorf("%s: replaying /big failed: %v", filepath.Base(path), err)
+			} else if response.StatusCode != http.StatusOK || len(body) == 0 || !strings.HasPrefix(big, body) || len(body) >= len(big) {
+				t.Errorf("%s: replayed /big as %d with %d bytes", filepath.Base(path), response.StatusCode, len(body))
+			}
+
+			if _, _, err := get(client, "/other", 1<<20); err == nil || !strings.Contains(err.Error(), "not in the WARC archive") {
+				t.Errorf("%s: replaying a URL not in the file returned %v, want an error", filepath.Base(path), err)
+			}
+		}
+	}
+}
+
+func TestSearchRecordsWholePages(t *testing.T) {
+	page := "<p>needle</p>" + strings.Repeat("<p>more text</p>", 20000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		io.WriteString(w, page)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		cache  bool
+		runs   int
+		stored int
+	}{
+		{name: "no cache", runs: 1, stored: 1},
+		{name: "cache", cache: true, runs: 2, stored: 2},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "run.warc")
+		w, err := CreateWARC(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := Options{Client: ClientOptions{WARC: w}}
+		if tt.cache {
+			if opts.Client.Cache, err = OpenCache(t.TempDir(), CacheOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// The search stops reading each page once the term is found,
+		// and the second run is served from the cache.
+		s := New(opts)
+		for i := 0; i < tt.runs; i++ {
+			results, err := s.Search(context.Background(), []string{"needle"}, []string{server.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !results[0].Found || !results[0].StoppedEarly {
+				t.Errorf("%s: run %d found %v, stopped early %v", tt.name, i+1, results[0].Found, results[0].StoppedEarly)
+			}
+		}
+		w.Close()
+
+		var responses int
+		for _, rec := range readWARCRecords(t, path) {
+			if rec.fields["WARC-Type"] != "response" {
+				continue
+			}
+			responses++
+			head, payload, _ := bytes.Cut(rec.block, []byte("\r\n\r\n"))
+			if rec.fields["WARC-Truncated"] != "" || string(payload) != page {
+				t.Errorf("%s: recorded %d of %d bytes, truncated %q", tt.name, len(payload), len(page), rec.fields["WARC-Truncated"])
+			}
+			if bytes.Contains(head, []byte(cacheStatusHeader)) {
+				t.Errorf("%s: recorded the %s header", tt.name, cacheStatusHeader)
+			}
+		}
+		if responses != tt.stored {
+			t.Errorf("%s: recorded %d responses, want %d", tt.name, responses, tt.stored)
+		}
+	}
+}
+
+// gzipFile gzips the file at src as a whole to dst.
+func gzipFile(t *testing.T, src, dst string) {
+	t.Helper()
+	b, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(b)
+	gz.Close()
+	if err := os.WriteFile(dst, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenWARCFromOtherTools(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	io.WriteString(gz, "<p>compressed</p>")
+	gz.Close()
+
+	// Records as written by other tools: the body recorded as received
+	// on the wire, a truncated body, and responses to ignore.
+	var file bytes.Buffer
+	record := func(fields, block string) {
+		fmt.Fprintf(&file, "WARC/1.0\r\n%sContent-Length: %d\r\n\r\n%s\r\n\r\n", fields, len(block), block)
+	}
+	record("WARC-Type: warcinfo\r\n", "software: other\r\n")
+	record("WARC-Type: response\r\nWARC-Target-URI: <http://example.com/gz>\r\nContent-Type: application/http; msgtype=response\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: "+strconv.Itoa(compressed.Len())+"\r\n\r\n"+compressed.String())
+	record("WARC-Type: response\r\nWARC-Target-URI: http://example.com/cut\r\nWARC-Truncated: length\r\nContent-Type: application/http; msgtype=response\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 1000\r\n\r\n<p>start")
+	record("WARC-Type: response\r\nWARC-Target-URI: http://example.com/page\r\nContent-Type: application/http; msgtype=response\r\n",
+		"HTTP/1.1 200 OK\r\n\r\nfirst")
+	record("WARC-Type: response\r\nWARC-Target-URI: http://example.com/page\r\nContent-Type: application/http; msgtype=response\r\n",
+		"HTTP/1.1 304 Not Modified\r\n\r\n")
+	record("WARC-Type: request\r\nWARC-Target-URI: http://example.com/request\r\nContent-Type: application/http; msgtype=request\r\n",
+		"GET /request HTTP/1.1\r\n\r\n")
+
+	path := filepath.Join(t.TempDir(), "other.warc")
+	if err := os.WriteFile(path, file.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := OpenWARC(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: archive.transport()}
+
+	tests := []struct {
+		url  string
+		body string
+		err  bool
+	}{
+		{url: "http://example.com/gz", body: "<p>compressed</p>"},
+		{url: "http://example.com/cut", body: "<p>start"},
+		{url: "http://example.com/page", body: "first"},
+		{url: "http://example.com/request", err: true},
+	}
+	for _, tt := range tests {
+		response, err := client.Get(tt.url)
+		if tt.err {
+			if err == nil {
+				response.Body.Close()
+				t.Errorf("replaying %s succeeded, want an error", tt.url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("replaying %s failed: %v", tt.url, err)
+			continue
+		}
+		body, err := io.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil || string(body) != tt.body {
+			t.Errorf("replayed %s as %q (%v), want %q", tt.url, body, err, tt.body)
+		}
+	}
+
+	// Invalid files are rejected.
+	bad := filepath.Join(t.TempDir(), "bad.warc")
+	os.WriteFile(bad, []byte("not a WARC file\r\n"), 0644)
+	if _, err := OpenWARC(bad); err == nil {
+		t.Errorf("OpenWARC succeeded on an invalid file")
+	}
+}